@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics an alert rule can watch.
+const (
+	MetricLoss    = "loss"    // Packet loss percentage
+	MetricLatency = "latency" // Average round-trip time in ms
+	MetricAnomaly = "anomaly" // Deviations of the average from the series' baseline
+)
+
+// Alert states sent to notifiers.
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// AlertRule fires when a series' metric stays above the threshold for the
+// rule's duration, and resolves on the first round back under it.
+// Notifications are held back while a silence or maintenance window
+// covers the rule and target.
+type AlertRule struct {
+	Name      string        `toml:"name"`
+	Target    string        `toml:"target"` // Empty for every target
+	Family    string        `toml:"family"` // Empty for every family
+	Metric    string        `toml:"metric"` // "loss", "latency" or "anomaly"
+	Threshold float64       `toml:"threshold"`
+	For       time.Duration `toml:"for"`    // How long the threshold must be exceeded, 0 to fire on the first round
+	Notify    []string      `toml:"notify"` // Names of the notifiers to send to
+}
+
+func (r AlertRule) validate(targets map[string]bool, notifiers map[string]bool) error {
+	if r.Target != "" && !targets[r.Target] {
+		return fmt.Errorf("unknown target %q", r.Target)
+	}
+	if r.Family != FamilyIPv4 && r.Family != FamilyIPv6 && r.Family != FamilyAny {
+		return fmt.Errorf("invalid family %q: must be \"4\" or \"6\"", r.Family)
+	}
+	if r.Metric != MetricLoss && r.Metric != MetricLatency && r.Metric != MetricAnomaly {
+		return fmt.Errorf("invalid metric %q: must be %q, %q or %q", r.Metric, MetricLoss, MetricLatency, MetricAnomaly)
+	}
+	if r.For < 0 {
+		return fmt.Errorf("for must not be negative")
+	}
+	if len(r.Notify) == 0 {
+		return fmt.Errorf("no notifiers")
+	}
+	for _, name := range r.Notify {
+		if !notifiers[name] {
+			return fmt.Errorf("unknown notifier %q", name)
+		}
+	}
+	return nil
+}
+
+func (r AlertRule) matches(stats *PingStats) bool {
+	return (r.Target == "" || r.Target == stats.Target) && (r.Family == "" || r.Family == stats.Family)
+}
+
+// value returns the round's value for the rule's metric. Rounds without
+// replies have no latency, and rounds without a baseline no anomaly score.
+func (r AlertRule) value(stats *PingStats) (float64, bool) {
+	switch r.Metric {
+	case MetricLoss:
+		return stats.PacketLoss, true
+	case MetricAnomaly:
+		if stats.AnomalyScore == nil {
+			return 0, false
+		}
+		return *stats.AnomalyScore, true
+	}
+	if stats.Avg == nil {
+		return 0, false
+	}
+	return *stats.Avg, true
+}
+
+// condition describes what the rule watches for, e.g. "packet loss above 50.0%".
+func (r AlertRule) condition() string {
+	if r.Metric == MetricLoss {
+		return fmt.Sprintf("packet loss above %.1f%%", r.Threshold)
+	}
+	if r.Metric == MetricAnomaly {
+		return fmt.Sprintf("latency %.1f deviations above baseline", r.Threshold)
+	}
+	return fmt.Sprintf("latency above %.1f ms", r.Threshold)
+}
+
+// Alert is a notification that a rule started or stopped firing for a series.
+type Alert struct {
+	Rule      string        `json:"rule"`
+	Status    string        `json:"status"` // "firing" or "resolved"
+	Target    string        `json:"target"`
+	Family    string        `json:"family"`
+	Series    string        `json:"series"`
+	Condition string        `json:"condition"`
+	Value     float64       `json:"value"` // The metric in the round that changed the status
+	Started   time.Time     `json:"started"`
+	Ended     *time.Time    `json:"ended"`   // Set once resolved
+	Summary   RoundsSummary `json:"summary"` // Rounds from the start of the incident
+}
+
+// Notifier delivers alerts, e.g. by email.
+type Notifier interface {
+	Notify(alert Alert) error
+}
+
+// Notifier types.
+const (
+	NotifierEmail    = "email"
+	NotifierSlack    = "slack"
+	NotifierDiscord  = "discord"
+	NotifierTelegram = "telegram"
+	NotifierNtfy     = "ntfy"
+	NotifierGotify   = "gotify"
+)
+
+// NotifierConfig is a named destination for alerts. Which settings apply
+// depends on the type.
+type NotifierConfig struct {
+	Name string `toml:"name"`
+	Type string `toml:"type"` // "email", "slack", "discord", "telegram", "ntfy" or "gotify"
+
+	// Email
+	Host     string   `toml:"host"`
+	Port     int      `toml:"port"` // Default 587 with STARTTLS, 465 with TLS, 25 without
+	TLS      string   `toml:"tls"`  // "starttls" (default), "tls" or "none"
+	Username string   `toml:"username"`
+	Password string   `toml:"password"`
+	From     string   `toml:"from"`
+	To       []string `toml:"to"`
+
+	// Chat services. url is the webhook for Slack and Discord, and the
+	// server for Telegram, ntfy and Gotify.
+	URL      string `toml:"url"`
+	Token    string `toml:"token"`    // Telegram bot token, Gotify app token or ntfy access token
+	ChatID   string `toml:"chat_id"`  // Telegram
+	Topic    string `toml:"topic"`    // ntfy
+	Priority int    `toml:"priority"` // ntfy (1-5) or Gotify (0-10) priority of firing alerts
+}
+
+func (c NotifierConfig) validate() error {
+	switch c.Type {
+	case NotifierEmail:
+		if c.Host == "" {
+			return fmt.Errorf("host is required")
+		}
+		if c.Port < 0 || c.Port > 65535 {
+			return fmt.Errorf("invalid port %d", c.Port)
+		}
+		switch c.TLS {
+		case "", SMTPStartTLS, SMTPTLS, SMTPNone:
+		default:
+			return fmt.Errorf("invalid tls %q: must be %q, %q or %q", c.TLS, SMTPStartTLS, SMTPTLS, SMTPNone)
+		}
+		if c.From == "" || len(c.To) == 0 {
+			return fmt.Errorf("from and to are required")
+		}
+		return nil
+	case NotifierSlack, NotifierDiscord:
+		if c.URL == "" {
+			return fmt.Errorf("url is required")
+		}
+		return nil
+	case NotifierTelegram:
+		if c.Token == "" || c.ChatID == "" {
+			return fmt.Errorf("token and chat_id are required")
+		}
+		return nil
+	case NotifierNtfy:
+		if c.Topic == "" {
+			return fmt.Errorf("topic is required")
+		}
+		if c.Priority < 0 || c.Priority > 5 {
+			return fmt.Errorf("invalid priority %d: must be between 1 and 5", c.Priority)
+		}
+		return nil
+	case NotifierGotify:
+		if c.URL == "" || c.Token == "" {
+			return fmt.Errorf("url and token are required")
+		}
+		if c.Priority < 0 || c.Priority > 10 {
+			return fmt.Errorf("invalid priority %d: must be between 0 and 10", c.Priority)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid type %q", c.Type)
+}
+
+// newNotifiers creates the configured notifiers, keyed by name.
+func newNotifiers(configs []NotifierConfig) map[string]Notifier {
+	notifiers := make(map[string]Notifier)
+	for _, c := range configs {
+		switch c.Type {
+		case NotifierEmail:
+			notifiers[c.Name] = newEmailNotifier(c)
+		case NotifierSlack:
+			notifiers[c.Name] = &slackNotifier{url: c.URL}
+		case NotifierDiscord:
+			notifiers[c.Name] = &discordNotifier{url: c.URL}
+		case NotifierTelegram:
+			notifiers[c.Name] = &telegramNotifier{url: serviceURL(c.URL, defaultTelegramURL), token: c.Token, chatID: c.ChatID}
+		case NotifierNtfy:
+			notifiers[c.Name] = &ntfyNotifier{url: serviceURL(c.URL, defaultNtfyURL), topic: c.Topic, token: c.Token, priority: c.Priority}
+		case NotifierGotify:
+			notifiers[c.Name] = &gotifyNotifier{url: serviceURL(c.URL, ""), token: c.Token, priority: c.Priority}
+		}
+	}
+	return notifiers
+}
+
+// serviceURL returns a notifier's server URL, or the service's public
+// server when none is set.
+func serviceURL(url, fallback string) string {
+	if url == "" {
+		url = fallback
+	}
+	return strings.TrimSuffix(url, "/")
+}
+
+type alertKey struct {
+	rule, target, family string
+}
+
+// alertState tracks a series that is over a rule's threshold.
+type alertState struct {
+	since      time.Time
+	firing     bool
+	notified   bool // The firing alert was sent
+	suppressed bool // The firing alert was held back by a silence
+	rounds     []PingStats
+}
+
+// alertManager evaluates every saved round against the alert rules and
+// sends the resulting alerts to the rules' notifiers.
+type alertManager struct {
+	rules     []AlertRule
+	notifiers map[string]Notifier
+	silencer  *silencer // nil when nothing is ever silenced
+
+	mu     sync.Mutex
+	states map[alertKey]*alertState
+}
+
+func newAlertManager(rules []AlertRule, notifiers map[string]Notifier) *alertManager {
+	return &alertManager{rules: rules, notifiers: notifiers, states: make(map[alertKey]*alertState)}
+}
+
+// evaluate updates the rules' state with a round and returns any alerts
+// that started or stopped firing.
+func (m *alertManager) evaluate(stats *PingStats) []Alert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var alerts []Alert
+	for _, rule := range m.rules {
+		if !rule.matches(stats) {
+			continue
+		}
+		key := alertKey{rule.Name, stats.Target, stats.Family}
+		state := m.states[key]
+
+		value, ok := rule.value(stats)
+		if !ok {
+			// Nothing to compare, so the state carries over
+			if state != nil {
+				state.rounds = append(state.rounds, *stats)
+			}
+			continue
+		}
+
+		if value > rule.Threshold {
+			if state == nil {
+				state = &alertState{since: stats.Timestamp}
+				m.states[key] = state
+			}
+			state.rounds = append(state.rounds, *stats)
+			if !state.firing && stats.Timestamp.Sub(state.since) >= rule.For {
+				state.firing = true
+			}
+			if !state.firing || state.notified {
+				continue
+			}
+			// A silence holds the alert back; it's sent if the silence
+			// ends while the alert is still firing
+			if reason := m.silencer.silenced(stats.Target, rule.Name, stats.Timestamp); reason != "" {
+				if !state.suppressed {
+					log.Printf("[%s] Alert %s is firing, notifications suppressed by %s", seriesLabel(stats.Target, stats.Family), rule.Name, reason)
+					state.suppressed = true
+				}
+				continue
+			}
+			state.notified = true
+			alerts = append(alerts, newAlert(rule, stats, state, AlertFiring, value))
+			continue
+		}
+
+		if state == nil {
+			continue
+		}
+		delete(m.states, key)
+		// Alerts that were sent are always resolved, even during a silence
+		if state.notified {
+			alert := newAlert(rule, stats, state, AlertResolved, value)
+			ended := stats.Timestamp
+			alert.Ended = &ended
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+func newAlert(rule AlertRule, stats *PingStats, state *alertState, status string, value float64) Alert {
+	return Alert{
+		Rule:      rule.Name,
+		Status:    status,
+		Target:    stats.Target,
+		Family:    stats.Family,
+		Series:    seriesLabel(stats.Target, stats.Family),
+		Condition: rule.condition(),
+		Value:     value,
+		Started:   state.since,
+		Summary:   summarizeRounds(state.rounds),
+	}
+}
+
+// observe evaluates a saved round and sends any alerts in the background,
+// so a slow mail server never holds up the monitor.
+func (m *alertManager) observe(stats *PingStats) {
+	if m == nil {
+		return
+	}
+	for _, alert := range m.evaluate(stats) {
+		log.Printf("[%s] Alert %s is %s: %s", alert.Series, alert.Rule, alert.Status, alert.Condition)
+		for _, name := range m.ruleNotifiers(alert.Rule) {
+			go func(name string, alert Alert) {
+				if err := m.notifiers[name].Notify(alert); err != nil {
+					log.Printf("[%s] Failed to send alert %s to %s: %v", alert.Series, alert.Rule, name, err)
+				}
+			}(name, alert)
+		}
+	}
+}
+
+func (m *alertManager) ruleNotifiers(rule string) []string {
+	for _, r := range m.rules {
+		if r.Name == rule {
+			return r.Notify
+		}
+	}
+	return nil
+}
+
+// alertSubject is a one-line description of an alert, e.g.
+// "[FIRING] isp-down: isp/IPv4 packet loss above 50.0%".
+func alertSubject(a Alert) string {
+	return fmt.Sprintf("[%s] %s: %s %s", strings.ToUpper(a.Status), a.Rule, a.Series, a.Condition)
+}
+
+// alertText summarizes an alert and the incident so far in plain text.
+func alertText(a Alert) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Rule: %s\n", a.Rule)
+	fmt.Fprintf(&b, "Series: %s\n", a.Series)
+	fmt.Fprintf(&b, "Condition: %s\n", a.Condition)
+	fmt.Fprintf(&b, "Status: %s\n", a.Status)
+	fmt.Fprintf(&b, "Started: %s\n", a.Started.Format(time.RFC1123))
+	if a.Ended != nil {
+		fmt.Fprintf(&b, "Ended: %s (%s)\n", a.Ended.Format(time.RFC1123), a.Ended.Sub(a.Started).Round(time.Second))
+	}
+	fmt.Fprintf(&b, "\nOver %d rounds:\n", a.Summary.Rounds)
+	fmt.Fprintf(&b, "Packet loss: %.1f%%\n", a.Summary.PacketLoss)
+	fmt.Fprintf(&b, "Availability: %.1f%%\n", a.Summary.Availability)
+	fmt.Fprintf(&b, "Latency: %s\n", formatLatency(a.Summary))
+	return b.String()
+}
+
+func formatLatency(s RoundsSummary) string {
+	if s.Avg == nil {
+		return "no replies"
+	}
+	return fmt.Sprintf("avg %.1f ms, p95 %.1f ms", *s.Avg, *s.P95)
+}
+
+var alertHTMLTemplate = template.Must(template.New("alert").Funcs(template.FuncMap{
+	"upper":   strings.ToUpper,
+	"latency": formatLatency,
+	"time":    func(t time.Time) string { return t.Format(time.RFC1123) },
+}).Parse(`<h2>{{upper .Status}}: {{.Rule}}</h2>
+<p><strong>{{.Series}}</strong> {{.Condition}}</p>
+<table>
+<tr><td>Started</td><td>{{time .Started}}</td></tr>
+{{- if .Ended}}
+<tr><td>Ended</td><td>{{time .Ended}}</td></tr>
+{{- end}}
+<tr><td>Rounds</td><td>{{.Summary.Rounds}}</td></tr>
+<tr><td>Packet loss</td><td>{{printf "%.1f" .Summary.PacketLoss}}%</td></tr>
+<tr><td>Availability</td><td>{{printf "%.1f" .Summary.Availability}}%</td></tr>
+<tr><td>Latency</td><td>{{latency .Summary}}</td></tr>
+</table>
+`))
+
+// alertHTML summarizes an alert and the incident so far as HTML.
+func alertHTML(a Alert) (string, error) {
+	var b bytes.Buffer
+	if err := alertHTMLTemplate.Execute(&b, a); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
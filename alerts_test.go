@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAlertManagerEvaluate(t *testing.T) {
+	rule := AlertRule{Name: "isp-down", Target: "isp", Metric: MetricLoss, Threshold: 50, For: 20 * time.Second, Notify: []string{"oncall"}}
+	m := newAlertManager([]AlertRule{rule}, nil)
+
+	base := time.Date(2025, 10, 19, 3, 0, 0, 0, time.UTC)
+	round := func(offset time.Duration, target string, loss float64, avg *float64) []Alert {
+		return m.evaluate(&PingStats{Timestamp: base.Add(offset), Target: target, Family: FamilyIPv4, PacketLoss: loss, Avg: avg})
+	}
+
+	if alerts := round(0, "isp", 100, nil); len(alerts) != 0 {
+		t.Fatalf("Expected no alert before the rule's duration, got %+v", alerts)
+	}
+	if alerts := round(10*time.Second, "google", 100, nil); len(alerts) != 0 {
+		t.Fatalf("Expected other targets to be ignored, got %+v", alerts)
+	}
+	if alerts := round(10*time.Second, "isp", 80, float64Ptr(40)); len(alerts) != 0 {
+		t.Fatalf("Expected no alert before the rule's duration, got %+v", alerts)
+	}
+
+	alerts := round(20*time.Second, "isp", 60, float64Ptr(30))
+	if len(alerts) != 1 || alerts[0].Status != AlertFiring {
+		t.Fatalf("Expected the alert to fire, got %+v", alerts)
+	}
+	if !alerts[0].Started.Equal(base) || alerts[0].Series != "isp/IPv4" || alerts[0].Summary.Rounds != 3 {
+		t.Errorf("Unexpected firing alert %+v", alerts[0])
+	}
+
+	// Still over the threshold: nothing new to send
+	if alerts := round(30*time.Second, "isp", 100, nil); len(alerts) != 0 {
+		t.Fatalf("Expected no repeat alert, got %+v", alerts)
+	}
+
+	alerts = round(40*time.Second, "isp", 0, float64Ptr(12))
+	if len(alerts) != 1 || alerts[0].Status != AlertResolved {
+		t.Fatalf("Expected the alert to resolve, got %+v", alerts)
+	}
+	resolved := alerts[0]
+	if resolved.Ended == nil || resolved.Ended.Sub(resolved.Started) != 40*time.Second {
+		t.Errorf("Expected a 40s incident, got %v to %v", resolved.Started, resolved.Ended)
+	}
+	if resolved.Summary.Rounds != 4 || resolved.Summary.PacketLoss != 85 || *resolved.Summary.Avg != 35 {
+		t.Errorf("Unexpected incident summary %+v", resolved.Summary)
+	}
+
+	// A blip shorter than the rule's duration never fires or resolves
+	round(50*time.Second, "isp", 100, nil)
+	if alerts := round(60*time.Second, "isp", 0, float64Ptr(12)); len(alerts) != 0 {
+		t.Errorf("Expected no alerts for a short blip, got %+v", alerts)
+	}
+}
+
+func TestAlertManagerLatency(t *testing.T) {
+	rule := AlertRule{Name: "slow", Metric: MetricLatency, Threshold: 100, Notify: []string{"oncall"}}
+	m := newAlertManager([]AlertRule{rule}, nil)
+	base := time.Now()
+
+	alerts := m.evaluate(&PingStats{Timestamp: base, Target: "google", Avg: float64Ptr(150)})
+	if len(alerts) != 1 || alerts[0].Condition != "latency above 100.0 ms" {
+		t.Fatalf("Expected a latency alert, got %+v", alerts)
+	}
+	// A round without replies has no latency to compare
+	if alerts := m.evaluate(&PingStats{Timestamp: base.Add(time.Second), Target: "google", PacketLoss: 100}); len(alerts) != 0 {
+		t.Errorf("Expected no change without latency, got %+v", alerts)
+	}
+	if alerts := m.evaluate(&PingStats{Timestamp: base.Add(2 * time.Second), Target: "google", Avg: float64Ptr(20)}); len(alerts) != 1 {
+		t.Errorf("Expected the alert to resolve, got %+v", alerts)
+	}
+}
+
+func TestAlertMessages(t *testing.T) {
+	started := time.Date(2025, 10, 19, 3, 0, 0, 0, time.UTC)
+	ended := started.Add(12 * time.Minute)
+	alert := Alert{
+		Rule:      "isp-down",
+		Status:    AlertResolved,
+		Series:    "isp/IPv4",
+		Condition: "packet loss above 50.0%",
+		Started:   started,
+		Ended:     &ended,
+		Summary:   RoundsSummary{Rounds: 72, Availability: 50, PacketLoss: 62.5, Avg: float64Ptr(31.2), P95: float64Ptr(48)},
+	}
+
+	if got, want := alertSubject(alert), "[RESOLVED] isp-down: isp/IPv4 packet loss above 50.0%"; got != want {
+		t.Errorf("Expected subject %q, got %q", want, got)
+	}
+
+	text := alertText(alert)
+	for _, want := range []string{"Ended: Sun, 19 Oct 2025 03:12:00 UTC (12m0s)", "Over 72 rounds:", "Packet loss: 62.5%", "Latency: avg 31.2 ms, p95 48.0 ms"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected text to contain %q:\n%s", want, text)
+		}
+	}
+
+	html, err := alertHTML(alert)
+	if err != nil {
+		t.Fatalf("Failed to render HTML: %v", err)
+	}
+	for _, want := range []string{"<h2>RESOLVED: isp-down</h2>", "Sun, 19 Oct 2025 03:12:00 UTC", "<td>62.5%</td>"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("Expected HTML to contain %q:\n%s", want, html)
+		}
+	}
+}
+
+func TestAlertConfigValidation(t *testing.T) {
+	valid := func() Config {
+		config := getDefaultConfig()
+		config.Notifiers = []NotifierConfig{{Name: "oncall", Type: NotifierEmail, Host: "localhost", From: "pingo@example.com", To: []string{"ops@example.com"}}}
+		config.Alerts = []AlertRule{{Name: "down", Target: "8.8.8.8", Metric: MetricLoss, Threshold: 50, Notify: []string{"oncall"}}}
+		return config
+	}
+	if err := valid().validate(); err != nil {
+		t.Fatalf("Expected a valid config, got %v", err)
+	}
+
+	tests := map[string]func(*Config){
+		"unknown notifier": func(c *Config) { c.Alerts[0].Notify = []string{"pager"} },
+		"unknown target":   func(c *Config) { c.Alerts[0].Target = "isp" },
+		"unknown metric":   func(c *Config) { c.Alerts[0].Metric = "jitter" },
+		"duplicate alert":  func(c *Config) { c.Alerts = append(c.Alerts, c.Alerts[0]) },
+		"unknown type":     func(c *Config) { c.Notifiers[0].Type = "pager" },
+		"no recipients":    func(c *Config) { c.Notifiers[0].To = nil },
+		"invalid tls":      func(c *Config) { c.Notifiers[0].TLS = "ssl" },
+	}
+	for name, modify := range tests {
+		config := valid()
+		modify(&config)
+		if err := config.validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Annotation marks an event on the timeline, such as a router change or ISP
+// maintenance. End is set for events that span a range.
+type Annotation struct {
+	ID   int64      `json:"id"`
+	Time time.Time  `json:"time"`
+	End  *time.Time `json:"end,omitempty"`
+	Text string     `json:"text"`
+	Tags []string   `json:"tags"`
+}
+
+var errAnnotationNotFound = errors.New("annotation not found")
+
+func (a *Annotation) validate() error {
+	if a.Time.IsZero() {
+		return fmt.Errorf("time is required")
+	}
+	if a.End != nil && a.End.Before(a.Time) {
+		return fmt.Errorf("end must not be before time")
+	}
+	a.Text = strings.TrimSpace(a.Text)
+	if a.Text == "" {
+		return fmt.Errorf("text is required")
+	}
+	for i, tag := range a.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || strings.Contains(tag, ",") {
+			return fmt.Errorf("invalid tag %q", tag)
+		}
+		a.Tags[i] = tag
+	}
+	return nil
+}
+
+// Tags are stored as ",tag1,tag2," so a single tag can be matched with instr.
+func joinTags(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return "," + strings.Join(tags, ",") + ","
+}
+
+func splitTags(s string) []string {
+	s = strings.Trim(s, ",")
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+func nullableMillis(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return toEpochMillis(*t)
+}
+
+func createAnnotation(db *DB, a *Annotation) error {
+	if err := a.validate(); err != nil {
+		return err
+	}
+	result, err := db.Exec(`INSERT INTO annotations (time, end_time, text, tags) VALUES (?, ?, ?, ?)`,
+		toEpochMillis(a.Time), nullableMillis(a.End), a.Text, joinTags(a.Tags))
+	if err != nil {
+		return err
+	}
+	a.ID, err = result.LastInsertId()
+	return err
+}
+
+func updateAnnotation(db *DB, a *Annotation) error {
+	if err := a.validate(); err != nil {
+		return err
+	}
+	result, err := db.Exec(`UPDATE annotations SET time = ?, end_time = ?, text = ?, tags = ? WHERE id = ?`,
+		toEpochMillis(a.Time), nullableMillis(a.End), a.Text, joinTags(a.Tags), a.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errAnnotationNotFound
+	}
+	return nil
+}
+
+func deleteAnnotation(db *DB, id int64) error {
+	result, err := db.Exec(`DELETE FROM annotations WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errAnnotationNotFound
+	}
+	return nil
+}
+
+const selectAnnotationsSQL = `SELECT id, time, end_time, text, tags FROM annotations`
+
+func scanAnnotations(rows *sql.Rows) ([]Annotation, error) {
+	defer rows.Close()
+
+	annotations := []Annotation{}
+	for rows.Next() {
+		var a Annotation
+		var timestamp int64
+		var end sql.NullInt64
+		var tags string
+		if err := rows.Scan(&a.ID, &timestamp, &end, &a.Text, &tags); err != nil {
+			return nil, err
+		}
+		a.Time = fromEpochMillis(timestamp)
+		if end.Valid {
+			endTime := fromEpochMillis(end.Int64)
+			a.End = &endTime
+		}
+		a.Tags = splitTags(tags)
+		annotations = append(annotations, a)
+	}
+	return annotations, rows.Err()
+}
+
+func getAnnotation(db *DB, id int64) (*Annotation, error) {
+	rows, err := db.Query(selectAnnotationsSQL+` WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	annotations, err := scanAnnotations(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(annotations) == 0 {
+		return nil, errAnnotationNotFound
+	}
+	return &annotations[0], nil
+}
+
+// listAnnotations returns annotations overlapping start to end, oldest
+// first, optionally only those with the given tag.
+func listAnnotations(db *DB, start, end time.Time, tag string) ([]Annotation, error) {
+	rows, err := db.Query(selectAnnotationsSQL+`
+		WHERE time <= ? AND COALESCE(end_time, time) >= ? AND (? = '' OR instr(tags, ',' || ? || ',') > 0)
+		ORDER BY time ASC`,
+		toEpochMillis(end), toEpochMillis(start), tag, tag)
+	if err != nil {
+		return nil, err
+	}
+	return scanAnnotations(rows)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAnnotationsCRUD(t *testing.T) {
+	db, err := initDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	baseTime := time.Date(2025, 10, 19, 12, 0, 0, 0, time.UTC)
+	maintenanceEnd := baseTime.Add(2 * time.Hour)
+	router := &Annotation{Time: baseTime, Text: "New router", Tags: []string{"home"}}
+	maintenance := &Annotation{Time: baseTime.Add(time.Hour), End: &maintenanceEnd, Text: " ISP maintenance ", Tags: []string{"isp", "planned"}}
+	for _, a := range []*Annotation{router, maintenance} {
+		if err := createAnnotation(db, a); err != nil {
+			t.Fatalf("Failed to create annotation: %v", err)
+		}
+	}
+
+	got, err := getAnnotation(db, maintenance.ID)
+	if err != nil {
+		t.Fatalf("Failed to get annotation: %v", err)
+	}
+	if got.Text != "ISP maintenance" || got.End == nil || !got.End.Equal(maintenanceEnd) || len(got.Tags) != 2 {
+		t.Errorf("Unexpected annotation: %+v", got)
+	}
+
+	// The range overlaps the window even though it started before it
+	list, err := listAnnotations(db, baseTime.Add(90*time.Minute), baseTime.Add(3*time.Hour), "")
+	if err != nil {
+		t.Fatalf("Failed to list annotations: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != maintenance.ID {
+		t.Errorf("Expected only the maintenance window, got %+v", list)
+	}
+
+	list, _ = listAnnotations(db, baseTime.Add(-time.Hour), baseTime.Add(3*time.Hour), "plan")
+	if len(list) != 0 {
+		t.Errorf("Expected tags to match whole tags only, got %+v", list)
+	}
+	list, _ = listAnnotations(db, baseTime.Add(-time.Hour), baseTime.Add(3*time.Hour), "home")
+	if len(list) != 1 || list[0].ID != router.ID {
+		t.Errorf("Expected the router annotation for tag home, got %+v", list)
+	}
+
+	router.Text = "Replaced router"
+	router.Tags = nil
+	if err := updateAnnotation(db, router); err != nil {
+		t.Fatalf("Failed to update annotation: %v", err)
+	}
+	got, _ = getAnnotation(db, router.ID)
+	if got.Text != "Replaced router" || len(got.Tags) != 0 {
+		t.Errorf("Expected the update to be stored, got %+v", got)
+	}
+
+	if err := deleteAnnotation(db, router.ID); err != nil {
+		t.Fatalf("Failed to delete annotation: %v", err)
+	}
+	if _, err := getAnnotation(db, router.ID); !errors.Is(err, errAnnotationNotFound) {
+		t.Errorf("Expected not found after delete, got %v", err)
+	}
+	if err := deleteAnnotation(db, router.ID); !errors.Is(err, errAnnotationNotFound) {
+		t.Errorf("Expected not found deleting twice, got %v", err)
+	}
+}
+
+func TestAnnotationValidate(t *testing.T) {
+	now := time.Now()
+	before := now.Add(-time.Minute)
+
+	tests := []struct {
+		name string
+		a    Annotation
+	}{
+		{"no time", Annotation{Text: "x"}},
+		{"no text", Annotation{Time: now, Text: "  "}},
+		{"end before time", Annotation{Time: now, End: &before, Text: "x"}},
+		{"comma in tag", Annotation{Time: now, Text: "x", Tags: []string{"a,b"}}},
+		{"empty tag", Annotation{Time: now, Text: "x", Tags: []string{""}}},
+	}
+	for _, tt := range tests {
+		if err := tt.a.validate(); err == nil {
+			t.Errorf("%s: expected error, got nil", tt.name)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AnomalyConfig enables scoring each round's latency against what is normal
+// for the series at that hour of the week.
+type AnomalyConfig struct {
+	Deviations float64       `toml:"deviations"`  // Score at which a round is flagged, 0 to disable
+	History    time.Duration `toml:"history"`     // How much history the baseline is learned from (default 14 days)
+	MinSamples int           `toml:"min_samples"` // Rounds an hour-of-week needs before it has its own baseline (default 30)
+}
+
+func (c AnomalyConfig) enabled() bool {
+	return c.Deviations > 0
+}
+
+func (c AnomalyConfig) validate() error {
+	if c.Deviations < 0 {
+		return fmt.Errorf("deviations must not be negative")
+	}
+	if c.History < 0 || c.MinSamples < 0 {
+		return fmt.Errorf("history and min_samples must not be negative")
+	}
+	return nil
+}
+
+const (
+	defaultAnomalyHistory    = 14 * 24 * time.Hour
+	defaultAnomalyMinSamples = 30
+
+	// baselineRefresh is how often baselines are relearned from history.
+	baselineRefresh = time.Hour
+
+	// madScale makes the median absolute deviation comparable to a
+	// standard deviation for normally distributed latency.
+	madScale = 1.4826
+
+	// minMAD keeps a very steady link's baseline from flagging deviations
+	// of a few microseconds.
+	minMAD = 0.1
+)
+
+// latencyBaseline is the median latency of a set of rounds and their median
+// absolute deviation from it, both in ms.
+type latencyBaseline struct {
+	Median  float64 `json:"median"`
+	MAD     float64 `json:"mad"`
+	Samples int     `json:"samples"`
+}
+
+func newLatencyBaseline(latencies []float64) latencyBaseline {
+	sorted := append([]float64(nil), latencies...)
+	sort.Float64s(sorted)
+	median := medianOf(sorted)
+
+	deviations := make([]float64, len(sorted))
+	for i, l := range sorted {
+		deviations[i] = math.Abs(l - median)
+	}
+	sort.Float64s(deviations)
+	return latencyBaseline{Median: median, MAD: medianOf(deviations), Samples: len(sorted)}
+}
+
+func medianOf(sorted []float64) float64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// score returns how many deviations avg is from the median; positive when
+// slower than usual.
+func (b latencyBaseline) score(avg float64) float64 {
+	return (avg - b.Median) / (madScale * max(b.MAD, minMAD))
+}
+
+// hourOfWeek numbers the hours of the week from 0 (Sunday 00:00) in the
+// server's timezone, so the baseline follows local daily routines.
+func hourOfWeek(t time.Time) int {
+	local := t.In(time.Local)
+	return int(local.Weekday())*24 + local.Hour()
+}
+
+// seriesBaseline is a series' normal latency for each hour of the week,
+// with the whole history as the fallback for hours with too few rounds.
+type seriesBaseline struct {
+	hours   [7 * 24]*latencyBaseline
+	overall *latencyBaseline
+}
+
+func newSeriesBaseline(rounds []PingStats, minSamples int) *seriesBaseline {
+	var all []float64
+	var hours [7 * 24][]float64
+	for _, r := range rounds {
+		if r.Avg == nil {
+			continue
+		}
+		all = append(all, *r.Avg)
+		h := hourOfWeek(r.Timestamp)
+		hours[h] = append(hours[h], *r.Avg)
+	}
+
+	b := &seriesBaseline{}
+	if len(all) < minSamples {
+		return b
+	}
+	overall := newLatencyBaseline(all)
+	b.overall = &overall
+	for h, latencies := range hours {
+		if len(latencies) >= minSamples {
+			hour := newLatencyBaseline(latencies)
+			b.hours[h] = &hour
+		}
+	}
+	return b
+}
+
+// at returns the baseline for t's hour of the week.
+func (b *seriesBaseline) at(t time.Time) *latencyBaseline {
+	if hour := b.hours[hourOfWeek(t)]; hour != nil {
+		return hour
+	}
+	return b.overall
+}
+
+type seriesKey struct {
+	target, family string
+}
+
+// anomalyDetector learns each series' baseline from stored history and
+// scores new rounds against it.
+type anomalyDetector struct {
+	store  Store
+	config AnomalyConfig
+	series []SeriesInfo
+
+	mu        sync.RWMutex
+	baselines map[seriesKey]*seriesBaseline
+}
+
+func newAnomalyDetector(store Store, config AnomalyConfig, series []SeriesInfo) *anomalyDetector {
+	if config.History == 0 {
+		config.History = defaultAnomalyHistory
+	}
+	if config.MinSamples == 0 {
+		config.MinSamples = defaultAnomalyMinSamples
+	}
+	return &anomalyDetector{store: store, config: config, series: series, baselines: make(map[seriesKey]*seriesBaseline)}
+}
+
+// refresh relearns every series' baseline from the history before now.
+func (d *anomalyDetector) refresh(now time.Time) error {
+	for _, s := range d.series {
+		rounds, err := d.store.StatsBetween(s.Target, s.Family, now.Add(-d.config.History), now)
+		if err != nil {
+			return fmt.Errorf("failed to load history for %s: %v", s.Label, err)
+		}
+		baseline := newSeriesBaseline(rounds, d.config.MinSamples)
+
+		d.mu.Lock()
+		d.baselines[seriesKey{s.Target, s.Family}] = baseline
+		d.mu.Unlock()
+	}
+	return nil
+}
+
+// Run relearns the baselines on startup and then every baselineRefresh.
+func (d *anomalyDetector) Run() {
+	for {
+		if err := d.refresh(time.Now()); err != nil {
+			log.Printf("Anomaly detection: %v", err)
+		}
+		time.Sleep(baselineRefresh)
+	}
+}
+
+// score sets the round's anomaly score and reports whether it's flagged.
+// Rounds without replies, or without a baseline yet, get no score.
+func (d *anomalyDetector) score(stats *PingStats) bool {
+	if d == nil || stats.Avg == nil {
+		return false
+	}
+
+	d.mu.RLock()
+	series := d.baselines[seriesKey{stats.Target, stats.Family}]
+	d.mu.RUnlock()
+	if series == nil {
+		return false
+	}
+	baseline := series.at(stats.Timestamp)
+	if baseline == nil {
+		return false
+	}
+
+	score := baseline.score(*stats.Avg)
+	stats.AnomalyScore = &score
+	return score >= d.config.Deviations
+}
+
+// anomaliesResponse lists the flagged rounds of a series for the dashboard.
+type anomaliesResponse struct {
+	Deviations float64     `json:"deviations"` // 0 when detection is disabled
+	Anomalies  []PingStats `json:"anomalies"`
+}
+
+// flaggedRounds returns the rounds whose score reached the threshold.
+func flaggedRounds(rounds []PingStats, deviations float64) []PingStats {
+	flagged := []PingStats{}
+	for _, r := range rounds {
+		if r.AnomalyScore != nil && *r.AnomalyScore >= deviations {
+			flagged = append(flagged, r)
+		}
+	}
+	return flagged
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLatencyBaseline(t *testing.T) {
+	b := newLatencyBaseline([]float64{10, 12, 11, 50, 9})
+	if b.Median != 11 || b.MAD != 1 || b.Samples != 5 {
+		t.Errorf("Expected median 11 and MAD 1 from 5 samples, got %+v", b)
+	}
+	if score := b.score(11 + 3*madScale); math.Abs(score-3) > 1e-9 {
+		t.Errorf("Expected a score of 3, got %v", score)
+	}
+
+	// A perfectly steady link still needs a noticeable change to score high
+	steady := newLatencyBaseline([]float64{5, 5, 5, 5})
+	if score := steady.score(5.01); score > 0.1 {
+		t.Errorf("Expected a tiny change to score low, got %v", score)
+	}
+}
+
+func TestSeriesBaselineHourOfWeek(t *testing.T) {
+	day := time.Date(2025, 10, 19, 0, 0, 0, 0, time.Local)
+	var rounds []PingStats
+	for i := 0; i < 40; i++ {
+		// Evenings are busy, the rest of the day is quiet
+		rounds = append(rounds,
+			PingStats{Timestamp: day.Add(20*time.Hour + time.Duration(i)*time.Minute), Avg: float64Ptr(float64(40 + i%3))},
+			PingStats{Timestamp: day.Add(time.Duration(i) * time.Hour / 2), Avg: float64Ptr(float64(10 + i%3))},
+			PingStats{Timestamp: day.Add(time.Duration(i) * time.Minute), PacketLoss: 100},
+		)
+	}
+
+	b := newSeriesBaseline(rounds, 30)
+	if evening := b.at(day.Add(20*time.Hour + 30*time.Minute)); evening == nil || evening.Median != 41 {
+		t.Errorf("Expected the evening baseline at 41 ms, got %+v", evening)
+	}
+	// Too few rounds in any one morning hour: falls back to the whole history
+	if morning := b.at(day.Add(3 * time.Hour)); morning == nil || morning.Samples != 80 {
+		t.Errorf("Expected the overall baseline, got %+v", morning)
+	}
+	if b := newSeriesBaseline(rounds, 100); b.at(day) != nil {
+		t.Error("Expected no baseline with too little history")
+	}
+}
+
+func TestAnomalyDetector(t *testing.T) {
+	store := newMemStore(1000)
+	now := time.Now()
+	for i := 1; i <= 50; i++ {
+		stats := &PingStats{Timestamp: now.Add(-time.Duration(i) * time.Minute), Target: "isp", Family: FamilyIPv4, Avg: float64Ptr(float64(20 + i%3))}
+		if err := store.SaveStats(stats); err != nil {
+			t.Fatalf("Failed to save stats: %v", err)
+		}
+	}
+
+	targets := []TargetConfig{{Name: "isp", Host: "192.0.2.1", Family: FamilyIPv4}}
+	d := newAnomalyDetector(store, AnomalyConfig{Deviations: 5}, listSeries(targets))
+	if err := d.refresh(now); err != nil {
+		t.Fatalf("Failed to learn baselines: %v", err)
+	}
+
+	normal := &PingStats{Timestamp: now, Target: "isp", Family: FamilyIPv4, Avg: float64Ptr(21)}
+	if d.score(normal) || normal.AnomalyScore == nil || *normal.AnomalyScore != 0 {
+		t.Errorf("Expected a usual round to score 0, got %v", normal.AnomalyScore)
+	}
+	slow := &PingStats{Timestamp: now, Target: "isp", Family: FamilyIPv4, Avg: float64Ptr(40)}
+	if !d.score(slow) {
+		t.Errorf("Expected a 40 ms round to be flagged, got %v", *slow.AnomalyScore)
+	}
+	lost := &PingStats{Timestamp: now, Target: "isp", Family: FamilyIPv4, PacketLoss: 100}
+	if d.score(lost) || lost.AnomalyScore != nil {
+		t.Error("Expected no score without replies")
+	}
+	unknown := &PingStats{Timestamp: now, Target: "google", Family: FamilyIPv4, Avg: float64Ptr(40)}
+	if d.score(unknown) || unknown.AnomalyScore != nil {
+		t.Error("Expected no score without a baseline")
+	}
+
+	if flagged := flaggedRounds([]PingStats{*normal, *slow, *lost}, 5); len(flagged) != 1 || *flagged[0].Avg != 40 {
+		t.Errorf("Expected only the slow round to be flagged, got %+v", flagged)
+	}
+}
+
+func TestAlertManagerAnomaly(t *testing.T) {
+	rule := AlertRule{Name: "unusual", Metric: MetricAnomaly, Threshold: 4, For: time.Minute, Notify: []string{"oncall"}}
+	m := newAlertManager([]AlertRule{rule}, nil)
+	base := time.Now()
+
+	if alerts := m.evaluate(&PingStats{Timestamp: base, Target: "isp", AnomalyScore: float64Ptr(6)}); len(alerts) != 0 {
+		t.Fatalf("Expected the alert to wait for its duration, got %+v", alerts)
+	}
+	// Rounds without a score don't change the alert's state
+	m.evaluate(&PingStats{Timestamp: base.Add(30 * time.Second), Target: "isp", PacketLoss: 100})
+	alerts := m.evaluate(&PingStats{Timestamp: base.Add(time.Minute), Target: "isp", AnomalyScore: float64Ptr(5)})
+	if len(alerts) != 1 || alerts[0].Condition != "latency 4.0 deviations above baseline" {
+		t.Fatalf("Expected an anomaly alert, got %+v", alerts)
+	}
+	if alerts := m.evaluate(&PingStats{Timestamp: base.Add(2 * time.Minute), Target: "isp", AnomalyScore: float64Ptr(0.5)}); len(alerts) != 1 || alerts[0].Status != AlertResolved {
+		t.Errorf("Expected the alert to resolve, got %+v", alerts)
+	}
+}
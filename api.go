@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The /api/v1 endpoints return JSON objects rather than bare arrays, and
+// errors as {"error": "...", "status": 400} rather than plain text.
+
+// APIError is the body of every /api/v1 error response.
+type APIError struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeJSON(w, status, APIError{Error: fmt.Sprintf(format, args...), Status: status})
+}
+
+// TargetInfo describes a configured target and the series it is probed as.
+type TargetInfo struct {
+	Name     string       `json:"name"`
+	Host     string       `json:"host"`
+	Families []string     `json:"families"` // "4", "6", or "" when the system picks
+	Probe    ProbeOptions `json:"probe"`
+}
+
+type targetsResponse struct {
+	Targets []TargetInfo `json:"targets"`
+}
+
+type latestResponse struct {
+	Target string      `json:"target"`
+	Latest []PingStats `json:"latest"` // The most recent round of each family
+}
+
+type summaryResponse struct {
+	Target string    `json:"target"`
+	Family string    `json:"family"`
+	Range  string    `json:"range"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	RoundsSummary
+	ExcludedRounds int `json:"excluded_rounds,omitempty"` // Rounds left out for falling in silences or maintenance windows
+}
+
+type seriesResponse struct {
+	Target string      `json:"target"`
+	Family string      `json:"family"`
+	Start  time.Time   `json:"start"`
+	End    time.Time   `json:"end"`
+	Rounds []PingStats `json:"rounds"`
+}
+
+// parseRange parses a summary range: a Go duration such as "90m" or "24h",
+// or a number of days such as "7d".
+func parseRange(s string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid range %q: use a positive duration such as 1h, 24h or 7d", s)
+	}
+	return d, nil
+}
+
+// newAPIv1 returns the handler for everything under /api/v1/.
+func newAPIv1(store Store, targets []TargetConfig, slaExclusions *silencer) http.Handler {
+	mux := http.NewServeMux()
+
+	// lookup resolves the {name} path segment and the family parameter,
+	// writing the error response itself when either is invalid
+	lookup := func(w http.ResponseWriter, r *http.Request) (TargetConfig, string, bool) {
+		name := r.PathValue("name")
+		for _, t := range targets {
+			if t.Name != name {
+				continue
+			}
+			family := r.URL.Query().Get("family")
+			if family != "" && !slices.Contains(probeFamilies(t.Family), family) {
+				writeJSONError(w, http.StatusBadRequest, "target %q is not probed over family %q", name, family)
+				return t, "", false
+			}
+			return t, family, true
+		}
+		writeJSONError(w, http.StatusNotFound, "unknown target %q", name)
+		return TargetConfig{}, "", false
+	}
+
+	getOnly := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				w.Header().Set("Allow", "GET, HEAD")
+				writeJSONError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+				return
+			}
+			h(w, r)
+		}
+	}
+
+	mux.HandleFunc("/api/v1/targets", getOnly(func(w http.ResponseWriter, r *http.Request) {
+		response := targetsResponse{Targets: []TargetInfo{}}
+		for _, t := range targets {
+			response.Targets = append(response.Targets, TargetInfo{
+				Name:     t.Name,
+				Host:     t.Host,
+				Families: probeFamilies(t.Family),
+				Probe:    t.ProbeOptions,
+			})
+		}
+		writeJSON(w, http.StatusOK, response)
+	}))
+
+	mux.HandleFunc("/api/v1/targets/{name}/latest", getOnly(func(w http.ResponseWriter, r *http.Request) {
+		target, family, ok := lookup(w, r)
+		if !ok {
+			return
+		}
+
+		families := probeFamilies(target.Family)
+		if family != "" {
+			families = []string{family}
+		}
+		response := latestResponse{Target: target.Name, Latest: []PingStats{}}
+		for _, f := range families {
+			stats, err := store.RecentStats(target.Name, f, 1)
+			if err != nil {
+				writeJSONError(w, http.StatusInternalServerError, "%v", err)
+				return
+			}
+			response.Latest = append(response.Latest, stats...)
+		}
+		writeJSON(w, http.StatusOK, response)
+	}))
+
+	mux.HandleFunc("/api/v1/targets/{name}/summary", getOnly(func(w http.ResponseWriter, r *http.Request) {
+		target, family, ok := lookup(w, r)
+		if !ok {
+			return
+		}
+
+		rangeParam := r.URL.Query().Get("range")
+		if rangeParam == "" {
+			rangeParam = "24h"
+		}
+		duration, err := parseRange(rangeParam)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+
+		end := time.Now().UTC()
+		start := end.Add(-duration)
+		rounds, err := store.StatsBetween(target.Name, family, start, end)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+
+		total := len(rounds)
+		if slaExclusions != nil {
+			excluded, err := slaExclusions.excluded(target.Name, start, end)
+			if err != nil {
+				writeJSONError(w, http.StatusInternalServerError, "%v", err)
+				return
+			}
+			rounds = withoutExcluded(rounds, excluded)
+		}
+		writeJSON(w, http.StatusOK, summaryResponse{
+			Target:         target.Name,
+			Family:         family,
+			Range:          rangeParam,
+			Start:          start,
+			End:            end,
+			RoundsSummary:  summarizeRounds(rounds),
+			ExcludedRounds: total - len(rounds),
+		})
+	}))
+
+	mux.HandleFunc("/api/v1/targets/{name}/series", getOnly(func(w http.ResponseWriter, r *http.Request) {
+		target, family, ok := lookup(w, r)
+		if !ok {
+			return
+		}
+
+		// Default to the last hour
+		end := time.Now().UTC()
+		start := end.Add(-time.Hour)
+		for _, p := range []struct {
+			name string
+			dest *time.Time
+		}{{"start", &start}, {"end", &end}} {
+			value := r.URL.Query().Get(p.name)
+			if value == "" {
+				continue
+			}
+			t, err := parseTimestamp(value)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, "invalid %s: %v", p.name, err)
+				return
+			}
+			*p.dest = t
+		}
+		if end.Before(start) {
+			writeJSONError(w, http.StatusBadRequest, "end must not be before start")
+			return
+		}
+
+		rounds, err := store.StatsBetween(target.Name, family, start, end)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+		if rounds == nil {
+			rounds = []PingStats{}
+		}
+		writeJSON(w, http.StatusOK, seriesResponse{
+			Target: target.Name,
+			Family: family,
+			Start:  start,
+			End:    end,
+			Rounds: rounds,
+		})
+	}))
+
+	mux.HandleFunc("/api/v1/", func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusNotFound, "no such endpoint %s", r.URL.Path)
+	})
+
+	return mux
+}
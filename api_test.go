@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestAPI(t *testing.T) (http.Handler, time.Time) {
+	t.Helper()
+	return newTestAPIWithExclusions(t, nil)
+}
+
+func newTestAPIWithExclusions(t *testing.T, slaExclusions func(now time.Time) *silencer) (http.Handler, time.Time) {
+	t.Helper()
+
+	store := newMemStore(100)
+	now := time.Now().Truncate(time.Millisecond)
+	for i := 0; i < 10; i++ {
+		for _, family := range []string{FamilyIPv4, FamilyIPv6} {
+			stats := &PingStats{
+				Timestamp: now.Add(-time.Duration(i) * time.Minute),
+				Target:    "google",
+				Family:    family,
+				Avg:       float64Ptr(float64(10 + i)),
+			}
+			if i == 9 {
+				stats.Avg, stats.PacketLoss = nil, 100
+			}
+			if err := store.SaveStats(stats); err != nil {
+				t.Fatalf("Failed to save stats: %v", err)
+			}
+		}
+	}
+
+	targets := []TargetConfig{
+		{Name: "google", Host: "google.com", Family: FamilyBoth},
+		{Name: "isp", Host: "192.0.2.1"},
+	}
+	var exclusions *silencer
+	if slaExclusions != nil {
+		exclusions = slaExclusions(now)
+	}
+	return newAPIv1(store, targets, exclusions), now
+}
+
+func getJSON(t *testing.T, h http.Handler, url string, wantStatus int, v any) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	if rec.Code != wantStatus {
+		t.Fatalf("GET %s: expected status %d, got %d: %s", url, wantStatus, rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("GET %s: expected JSON, got %q", url, ct)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("GET %s: invalid JSON: %v", url, err)
+	}
+}
+
+func TestAPIv1Targets(t *testing.T) {
+	h, _ := newTestAPI(t)
+
+	var response targetsResponse
+	getJSON(t, h, "/api/v1/targets", http.StatusOK, &response)
+	if len(response.Targets) != 2 || response.Targets[0].Name != "google" || len(response.Targets[0].Families) != 2 {
+		t.Errorf("Unexpected targets: %+v", response.Targets)
+	}
+}
+
+func TestAPIv1Latest(t *testing.T) {
+	h, now := newTestAPI(t)
+
+	var response latestResponse
+	getJSON(t, h, "/api/v1/targets/google/latest", http.StatusOK, &response)
+	if len(response.Latest) != 2 {
+		t.Fatalf("Expected the latest round of each family, got %d", len(response.Latest))
+	}
+	for _, s := range response.Latest {
+		if !s.Timestamp.Equal(now) {
+			t.Errorf("Expected the latest round at %v, got %v", now, s.Timestamp)
+		}
+	}
+
+	getJSON(t, h, "/api/v1/targets/google/latest?family=6", http.StatusOK, &response)
+	if len(response.Latest) != 1 || response.Latest[0].Family != FamilyIPv6 {
+		t.Errorf("Expected only the IPv6 round, got %+v", response.Latest)
+	}
+
+	// A configured target with no rounds yet
+	getJSON(t, h, "/api/v1/targets/isp/latest", http.StatusOK, &response)
+	if response.Latest == nil || len(response.Latest) != 0 {
+		t.Errorf("Expected an empty list, got %+v", response.Latest)
+	}
+}
+
+func TestAPIv1Summary(t *testing.T) {
+	h, _ := newTestAPI(t)
+
+	var response summaryResponse
+	getJSON(t, h, "/api/v1/targets/google/summary?range=1h&family=4", http.StatusOK, &response)
+	if response.Rounds != 10 || response.Availability != 90 || response.PacketLoss != 10 {
+		t.Errorf("Expected 10 rounds, 90%% availability, 10%% loss; got %+v", response.RoundsSummary)
+	}
+	if response.Avg == nil || *response.Avg != 14 {
+		t.Errorf("Expected avg 14, got %v", response.Avg)
+	}
+
+	// Only the last 5 minutes
+	getJSON(t, h, "/api/v1/targets/google/summary?range=270s&family=4", http.StatusOK, &response)
+	if response.Rounds != 5 {
+		t.Errorf("Expected 5 rounds in range, got %d", response.Rounds)
+	}
+}
+
+func TestAPIv1SummaryExcludesSilences(t *testing.T) {
+	h, _ := newTestAPIWithExclusions(t, func(now time.Time) *silencer {
+		db, err := initDB(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("Failed to initialize database: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		// Covers the oldest round, the one without replies
+		silence := Silence{Target: "google", Starts: now.Add(-10 * time.Minute), Expires: now.Add(-510 * time.Second)}
+		if err := createSilence(db, &silence); err != nil {
+			t.Fatalf("Failed to create silence: %v", err)
+		}
+		return newSilencer(db, nil)
+	})
+
+	var response summaryResponse
+	getJSON(t, h, "/api/v1/targets/google/summary?range=1h&family=4", http.StatusOK, &response)
+	if response.Rounds != 9 || response.ExcludedRounds != 1 || response.Availability != 100 {
+		t.Errorf("Expected 9 rounds at 100%% availability with 1 excluded, got %+v", response)
+	}
+}
+
+func TestAPIv1Series(t *testing.T) {
+	h, now := newTestAPI(t)
+
+	var response seriesResponse
+	url := "/api/v1/targets/google/series?family=4&start=" + now.Add(-150*time.Second).Format(time.RFC3339Nano)
+	getJSON(t, h, url, http.StatusOK, &response)
+	if len(response.Rounds) != 3 {
+		t.Errorf("Expected 3 rounds since start, got %d", len(response.Rounds))
+	}
+}
+
+func TestAPIv1Errors(t *testing.T) {
+	h, _ := newTestAPI(t)
+
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{"/api/v1/targets/unknown/latest", http.StatusNotFound},
+		{"/api/v1/targets/isp/latest?family=6", http.StatusBadRequest},
+		{"/api/v1/targets/google/summary?range=yesterday", http.StatusBadRequest},
+		{"/api/v1/targets/google/summary?range=-1h", http.StatusBadRequest},
+		{"/api/v1/targets/google/series?start=19/10/2025", http.StatusBadRequest},
+		{"/api/v1/targets/google/series?start=2025-10-19T12:00:00Z&end=2025-10-19T11:00:00Z", http.StatusBadRequest},
+		{"/api/v1/nothing", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		var response APIError
+		getJSON(t, h, tt.url, tt.status, &response)
+		if response.Status != tt.status || response.Error == "" {
+			t.Errorf("GET %s: unexpected error body %+v", tt.url, response)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/targets", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected a JSON 405 for POST, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := map[string]time.Duration{
+		"24h": 24 * time.Hour,
+		"90m": 90 * time.Minute,
+		"7d":  7 * 24 * time.Hour,
+	}
+	for input, want := range tests {
+		got, err := parseRange(input)
+		if err != nil || got != want {
+			t.Errorf("parseRange(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"", "0h", "d", "-2d", "week"} {
+		if _, err := parseRange(input); err == nil {
+			t.Errorf("parseRange(%q): expected error, got nil", input)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupPrefix and backupSuffix surround the UTC time in scheduled backup
+// file names, so that sorting the names sorts the backups by age.
+const (
+	backupPrefix = "pingo-backup-"
+	backupSuffix = ".db"
+)
+
+// backupDB writes a consistent copy of the database to dest with VACUUM
+// INTO, which is safe while the monitor is writing. The copy is made under
+// a temporary name first so dest never holds a partial backup.
+func backupDB(db *sql.DB, dest string) error {
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("%s already exists", dest)
+	}
+
+	tmp := dest + ".tmp"
+	os.Remove(tmp)
+	if _, err := db.Exec(`VACUUM INTO ?`, tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to back up database: %v", err)
+	}
+	return os.Rename(tmp, dest)
+}
+
+// createScheduledBackup writes a timestamped backup to dir and deletes all
+// but the newest keep backups there.
+func createScheduledBackup(db *DB, dir string, keep int, now time.Time) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	dest := filepath.Join(dir, backupPrefix+now.UTC().Format("20060102T150405Z")+backupSuffix)
+	if err := backupDB(db.DB, dest); err != nil {
+		return "", err
+	}
+	return dest, rotateBackups(dir, keep)
+}
+
+func rotateBackups(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var backups []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), backupPrefix) && strings.HasSuffix(e.Name(), backupSuffix) {
+			backups = append(backups, e.Name())
+		}
+	}
+	sort.Strings(backups)
+
+	for len(backups) > keep {
+		if err := os.Remove(filepath.Join(dir, backups[0])); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+func runBackups(db *DB, dir string, interval time.Duration, keep int) {
+	log.Printf("Backing up the database to %s every %s, keeping %d", dir, interval, keep)
+	for {
+		time.Sleep(interval)
+		dest, err := createScheduledBackup(db, dir, keep, time.Now())
+		if err != nil {
+			log.Printf("Backup failed: %v", err)
+			continue
+		}
+		log.Printf("Backed up database to %s", dest)
+	}
+}
+
+// validateBackup checks that path is an intact pingo database whose schema
+// this version understands.
+func validateBackup(path string) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var integrity string
+	if err := db.QueryRow(`PRAGMA integrity_check`).Scan(&integrity); err != nil {
+		return fmt.Errorf("not a SQLite database: %v", err)
+	}
+	if integrity != "ok" {
+		return fmt.Errorf("integrity check failed: %s", integrity)
+	}
+
+	version, err := getSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > schemaVersion() {
+		return fmt.Errorf("backup schema version %d is newer than this version of pingo supports (%d)",
+			version, schemaVersion())
+	}
+
+	var tables int
+	err = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'ping_stats'`).Scan(&tables)
+	if err != nil {
+		return err
+	}
+	if tables == 0 {
+		return fmt.Errorf("not a pingo database: no ping_stats table")
+	}
+	return nil
+}
+
+// restoreDB replaces the database at dbPath with a copy of src once the
+// copy has been validated. It refuses while pingo, or anything else, has
+// the database open. Backups from older versions are migrated when the
+// database is next opened.
+func restoreDB(src, dbPath string) error {
+	tmp := dbPath + ".restore"
+	if err := copyFile(src, tmp); err != nil {
+		return fmt.Errorf("failed to copy backup: %v", err)
+	}
+	if err := validateBackup(tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("invalid backup %s: %v", src, err)
+	}
+
+	// Refuse to pull the database out from under a running pingo
+	if _, err := os.Stat(dbPath); err == nil {
+		unlock, err := lockDB(dbPath)
+		if err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("%s is in use, stop pingo before restoring: %v", dbPath, err)
+		}
+		defer unlock()
+	}
+
+	// A leftover WAL belongs to the database being replaced
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !os.IsNotExist(err) {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	return os.Rename(tmp, dbPath)
+}
+
+// lockDB takes an exclusive lock on the database at path, which fails if
+// any other connection has it open. In WAL mode BEGIN EXCLUSIVE alone only
+// keeps out other writers, hence the exclusive locking mode.
+func lockDB(path string) (unlock func(), err error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(0)&_pragma=locking_mode(exclusive)")
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, `BEGIN EXCLUSIVE`); err != nil {
+		conn.Close()
+		db.Close()
+		return nil, err
+	}
+	return func() {
+		conn.ExecContext(ctx, `ROLLBACK`)
+		conn.Close()
+		db.Close()
+	}, nil
+}
+
+// openReadOnly opens the database at path for reading only, without the
+// migrations and journal settings openDB applies, so that it can be read
+// beside a running pingo of any version.
+func openReadOnly(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return sql.Open("sqlite", "file:"+path+"?mode=ro&_pragma=busy_timeout(5000)")
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// runBackupCommand implements "pingo backup <dest>".
+func runBackupCommand(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pingo backup [options] <dest>")
+		fs.PrintDefaults()
+	}
+	config, err := commandConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	db, err := openReadOnly(config.DBPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := backupDB(db, fs.Arg(0)); err != nil {
+		return err
+	}
+	log.Printf("Backed up %s to %s", config.DBPath, fs.Arg(0))
+	return nil
+}
+
+// runRestoreCommand implements "pingo restore <src>".
+func runRestoreCommand(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pingo restore [options] <src>")
+		fmt.Fprintln(fs.Output(), "Stop pingo before restoring; the current database is replaced.")
+		fs.PrintDefaults()
+	}
+	config, err := commandConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(config.DBPath), 0755); err != nil {
+		return err
+	}
+	if err := restoreDB(fs.Arg(0), config.DBPath); err != nil {
+		return err
+	}
+
+	// Bring an older backup up to the current schema now rather than on
+	// the next start
+	db, err := openDB(config.DBPath, config.dbOptions())
+	if err != nil {
+		return err
+	}
+	db.Close()
+
+	log.Printf("Restored %s from %s", config.DBPath, fs.Arg(0))
+	return nil
+}
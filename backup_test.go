@@ -0,0 +1,208 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "ping_stats.db")
+
+	db, err := initDB(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	baseTime := time.Now().Truncate(time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if err := savePingStats(db, &PingStats{Timestamp: baseTime.Add(time.Duration(i) * time.Second), Target: "isp"}); err != nil {
+			t.Fatalf("Failed to save stats: %v", err)
+		}
+	}
+
+	backupPath := filepath.Join(dir, "backup.db")
+	if err := backupDB(db.DB, backupPath); err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+	if err := backupDB(db.DB, backupPath); err == nil {
+		t.Error("Expected error when the backup destination exists, got nil")
+	}
+
+	// Rounds saved after the backup are lost on restore
+	if err := savePingStats(db, &PingStats{Timestamp: baseTime.Add(time.Hour), Target: "isp"}); err != nil {
+		t.Fatalf("Failed to save stats: %v", err)
+	}
+	db.Close()
+
+	if err := restoreDB(backupPath, dbPath); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+
+	db, err = initDB(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open restored database: %v", err)
+	}
+	defer db.Close()
+
+	stats, err := getRecentStats(db, "isp", "", 10)
+	if err != nil {
+		t.Fatalf("Failed to query restored database: %v", err)
+	}
+	if len(stats) != 3 {
+		t.Errorf("Expected 3 rounds from the backup, got %d", len(stats))
+	}
+}
+
+func TestRestoreRejectsInvalidBackups(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "ping_stats.db")
+
+	db, err := initDB(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	db.Close()
+
+	newer := filepath.Join(dir, "newer.db")
+	raw, err := sql.Open("sqlite", newer)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if _, err := raw.Exec(`CREATE TABLE ping_stats (id INTEGER); PRAGMA user_version = 9999`); err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	raw.Close()
+
+	notDB := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(notDB, []byte("not a database"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	tests := []struct {
+		src, want string
+	}{
+		{newer, "newer"},
+		{notDB, "not a SQLite database"},
+		{filepath.Join(dir, "missing.db"), "failed to copy"},
+	}
+	for _, tt := range tests {
+		err := restoreDB(tt.src, dbPath)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("restoreDB(%s): expected error containing %q, got %v", filepath.Base(tt.src), tt.want, err)
+		}
+	}
+
+	// The live database is left in place
+	if err := validateBackup(dbPath); err != nil {
+		t.Errorf("Expected the live database to be untouched: %v", err)
+	}
+	if _, err := os.Stat(dbPath + ".restore"); !os.IsNotExist(err) {
+		t.Error("Expected the temporary copy to be removed")
+	}
+}
+
+func TestRestoreRefusesOpenDatabase(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "ping_stats.db")
+
+	db, err := initDB(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	if err := savePingStats(db, &PingStats{Timestamp: time.Now(), Target: "isp"}); err != nil {
+		t.Fatalf("Failed to save stats: %v", err)
+	}
+	backupPath := filepath.Join(dir, "backup.db")
+	if err := backupDB(db.DB, backupPath); err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+
+	// The monitor still has the database open
+	err = restoreDB(backupPath, dbPath)
+	if err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("Expected the restore to be refused, got %v", err)
+	}
+	if _, err := os.Stat(dbPath + "-wal"); err != nil {
+		t.Errorf("Expected the live WAL to be left alone: %v", err)
+	}
+	if _, err := os.Stat(dbPath + ".restore"); !os.IsNotExist(err) {
+		t.Error("Expected the temporary copy to be removed")
+	}
+
+	db.Close()
+	if err := restoreDB(backupPath, dbPath); err != nil {
+		t.Errorf("Failed to restore once the database was closed: %v", err)
+	}
+}
+
+func TestBackupReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "ping_stats.db")
+
+	// A database left at an older schema by a pingo that is still running
+	raw, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if _, err := raw.Exec(`CREATE TABLE ping_stats (id INTEGER); PRAGMA user_version = 1`); err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer raw.Close()
+
+	db, err := openReadOnly(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE other (id INTEGER)`); err == nil {
+		t.Error("Expected writes to a read-only database to fail")
+	}
+	if err := backupDB(db, filepath.Join(dir, "backup.db")); err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+
+	if version, err := getSchemaVersion(raw); err != nil || version != 1 {
+		t.Errorf("Expected the source to stay at schema version 1, got %d (%v)", version, err)
+	}
+	if _, err := openReadOnly(filepath.Join(dir, "missing.db")); err == nil {
+		t.Error("Expected an error for a missing database")
+	}
+}
+
+func TestScheduledBackupRotation(t *testing.T) {
+	dir := t.TempDir()
+	db, err := initDB(filepath.Join(dir, "ping_stats.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	backupDir := filepath.Join(dir, "backups")
+	baseTime := time.Date(2025, 10, 19, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		if _, err := createScheduledBackup(db, backupDir, 3, baseTime.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatalf("Failed to create backup: %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(backupDir)
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	expected := []string{
+		"pingo-backup-20251019T140000Z.db",
+		"pingo-backup-20251019T150000Z.db",
+		"pingo-backup-20251019T160000Z.db",
+	}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected the newest 3 backups %v, got %v", expected, names)
+	}
+}
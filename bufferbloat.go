@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BufferbloatConfig configures the loaded latency test. The link is
+// saturated against DownloadURL and/or UploadURL while pinging Target.
+type BufferbloatConfig struct {
+	Target      string        `toml:"target"`       // Target name to ping (default: first target)
+	DownloadURL string        `toml:"download_url"` // Fetched repeatedly during the download phase
+	UploadURL   string        `toml:"upload_url"`   // Receives POSTs during the upload phase
+	Duration    time.Duration `toml:"duration"`     // Length of each phase (default 10s)
+	Streams     int           `toml:"streams"`      // Parallel HTTP connections (default 4)
+	Interval    time.Duration `toml:"interval"`     // Scheduled run interval, 0 for on-demand only
+}
+
+func (c BufferbloatConfig) enabled() bool {
+	return c.DownloadURL != "" || c.UploadURL != ""
+}
+
+// BufferbloatResult compares idle latency with latency while the link is
+// saturated in each direction. Latencies are nil when a phase was skipped
+// or every ping was lost.
+type BufferbloatResult struct {
+	Timestamp       time.Time `json:"timestamp"`
+	Target          string    `json:"target"`
+	Family          string    `json:"family"`
+	IdleLatency     *float64  `json:"idle_latency"`
+	DownloadLatency *float64  `json:"download_latency"`
+	UploadLatency   *float64  `json:"upload_latency"`
+	DownloadDelta   *float64  `json:"download_delta"` // Loaded minus idle latency in ms
+	UploadDelta     *float64  `json:"upload_delta"`
+	DownloadMbps    float64   `json:"download_mbps"`
+	UploadMbps      float64   `json:"upload_mbps"`
+	Grade           string    `json:"grade"`
+}
+
+// bufferbloatGrade rates the worst latency increase under load, using the
+// same thresholds as the common web-based bufferbloat tests.
+func bufferbloatGrade(deltaMs float64) string {
+	switch {
+	case deltaMs < 5:
+		return "A+"
+	case deltaMs < 30:
+		return "A"
+	case deltaMs < 60:
+		return "B"
+	case deltaMs < 200:
+		return "C"
+	case deltaMs < 400:
+		return "D"
+	default:
+		return "F"
+	}
+}
+
+// errBufferbloatRunning is returned when a test is requested while another
+// one is still saturating the link.
+var errBufferbloatRunning = errors.New("a bufferbloat test is already running")
+
+type bufferbloatRunner struct {
+	db     *DB
+	config BufferbloatConfig
+	target TargetConfig
+	client *http.Client
+
+	// ping measures latency to the target over the given duration
+	ping func(duration time.Duration) *PingStats
+
+	running sync.Mutex
+}
+
+func newBufferbloatRunner(db *DB, config BufferbloatConfig, target TargetConfig) *bufferbloatRunner {
+	if config.Duration <= 0 {
+		config.Duration = 10 * time.Second
+	}
+	if config.Streams <= 0 {
+		config.Streams = 4
+	}
+
+	family := probeFamilies(target.Family)[0]
+	return &bufferbloatRunner{
+		db:     db,
+		config: config,
+		target: target,
+		client: &http.Client{},
+		ping: func(duration time.Duration) *PingStats {
+			count := int(duration.Seconds())
+			if count < 1 {
+				count = 1
+			}
+			output, _, _ := runPing(target.Host, count, family, target.ProbeOptions)
+			stats, _ := parsePingStats(output)
+			return stats
+		},
+	}
+}
+
+// Run performs an idle phase followed by download and upload phases and
+// stores the result. Only one test runs at a time.
+func (b *bufferbloatRunner) Run() (*BufferbloatResult, error) {
+	if !b.running.TryLock() {
+		return nil, errBufferbloatRunning
+	}
+	defer b.running.Unlock()
+
+	result := &BufferbloatResult{
+		Timestamp: time.Now(),
+		Target:    b.target.Name,
+		Family:    probeFamilies(b.target.Family)[0],
+	}
+
+	log.Printf("Bufferbloat test: measuring idle latency to %s", b.target.Name)
+	result.IdleLatency = b.ping(b.config.Duration).Avg
+	if result.IdleLatency == nil {
+		return nil, fmt.Errorf("no replies from %s while idle", b.target.Name)
+	}
+
+	worst := 0.0
+	if b.config.DownloadURL != "" {
+		log.Printf("Bufferbloat test: measuring latency during download from %s", b.config.DownloadURL)
+		latency, mbps, err := b.measureLoaded(http.MethodGet, b.config.DownloadURL)
+		if err != nil {
+			return nil, fmt.Errorf("download phase failed: %v", err)
+		}
+		result.DownloadLatency, result.DownloadMbps = latency, mbps
+		result.DownloadDelta = latencyDelta(result.IdleLatency, latency)
+		worst = max(worst, deltaOrWorst(result.DownloadDelta))
+	}
+	if b.config.UploadURL != "" {
+		log.Printf("Bufferbloat test: measuring latency during upload to %s", b.config.UploadURL)
+		latency, mbps, err := b.measureLoaded(http.MethodPost, b.config.UploadURL)
+		if err != nil {
+			return nil, fmt.Errorf("upload phase failed: %v", err)
+		}
+		result.UploadLatency, result.UploadMbps = latency, mbps
+		result.UploadDelta = latencyDelta(result.IdleLatency, latency)
+		worst = max(worst, deltaOrWorst(result.UploadDelta))
+	}
+	result.Grade = bufferbloatGrade(worst)
+
+	if err := saveBufferbloatResult(b.db, result); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Bufferbloat test: grade %s (download %.1f Mbps, upload %.1f Mbps)",
+		result.Grade, result.DownloadMbps, result.UploadMbps)
+	return result, nil
+}
+
+// measureLoaded pings the target while saturating the link and returns the
+// average latency and the throughput achieved. A phase whose requests
+// failed put no load on the link, so its latency is reported as an error
+// rather than graded.
+func (b *bufferbloatRunner) measureLoaded(method, url string) (*float64, float64, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var transferred, failed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < b.config.Streams; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			generateLoad(ctx, b.client, method, url, &transferred, &failed)
+		}()
+	}
+
+	start := time.Now()
+	stats := b.ping(b.config.Duration)
+	cancel()
+	wg.Wait()
+
+	if n := failed.Load(); n > 0 {
+		return nil, 0, fmt.Errorf("%d requests to %s failed", n, url)
+	}
+	if transferred.Load() == 0 {
+		return nil, 0, fmt.Errorf("no data was transferred with %s", url)
+	}
+
+	seconds := time.Since(start).Seconds()
+	mbps := float64(transferred.Load()) * 8 / seconds / 1e6
+	return stats.Avg, mbps, nil
+}
+
+// generateLoad repeatedly downloads from (GET) or uploads to (POST) url
+// until ctx is cancelled, adding the bytes moved to transferred and counting
+// requests that errored or got a non-2xx response in failed.
+func generateLoad(ctx context.Context, client *http.Client, method, url string, transferred, failed *atomic.Int64) {
+	for ctx.Err() == nil {
+		var body io.Reader
+		if method == http.MethodPost {
+			body = &countingZeroReader{ctx: ctx, n: transferred}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, url, body)
+		if err != nil {
+			log.Printf("Bufferbloat test: invalid URL %s: %v", url, err)
+			return
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() == nil {
+				failed.Add(1)
+				log.Printf("Bufferbloat test: %s %s failed: %v", method, url, err)
+				// Avoid spinning on a dead endpoint
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			resp.Body.Close()
+			failed.Add(1)
+			log.Printf("Bufferbloat test: %s %s returned %s", method, url, resp.Status)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		if method == http.MethodGet {
+			n, _ := io.Copy(io.Discard, resp.Body)
+			transferred.Add(n)
+		} else {
+			io.Copy(io.Discard, resp.Body)
+		}
+		resp.Body.Close()
+	}
+}
+
+// countingZeroReader yields zeros until ctx is cancelled, counting the
+// bytes read so upload throughput can be reported.
+type countingZeroReader struct {
+	ctx context.Context
+	n   *atomic.Int64
+}
+
+func (r *countingZeroReader) Read(p []byte) (int, error) {
+	if r.ctx.Err() != nil {
+		return 0, io.EOF
+	}
+	clear(p)
+	r.n.Add(int64(len(p)))
+	return len(p), nil
+}
+
+func latencyDelta(idle, loaded *float64) *float64 {
+	if idle == nil || loaded == nil {
+		return nil
+	}
+	delta := *loaded - *idle
+	return &delta
+}
+
+// deltaOrWorst treats a phase where every ping was lost as the worst grade.
+func deltaOrWorst(delta *float64) float64 {
+	if delta == nil {
+		return 1e9
+	}
+	return *delta
+}
+
+func runBufferbloatSchedule(runner *bufferbloatRunner, interval time.Duration) {
+	log.Printf("Scheduling bufferbloat tests every %s", interval)
+	for {
+		time.Sleep(interval)
+		if _, err := runner.Run(); err != nil {
+			log.Printf("Bufferbloat test failed: %v", err)
+		}
+	}
+}
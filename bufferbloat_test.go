@@ -0,0 +1,170 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBufferbloatGrade(t *testing.T) {
+	tests := []struct {
+		delta float64
+		want  string
+	}{
+		{-1, "A+"},
+		{4.9, "A+"},
+		{5, "A"},
+		{45, "B"},
+		{150, "C"},
+		{399, "D"},
+		{400, "F"},
+	}
+
+	for _, tt := range tests {
+		if got := bufferbloatGrade(tt.delta); got != tt.want {
+			t.Errorf("bufferbloatGrade(%v) = %s, want %s", tt.delta, got, tt.want)
+		}
+	}
+}
+
+func TestBufferbloatRun(t *testing.T) {
+	// Local stand-in for the load endpoints; tracks which direction is busy
+	var downloading, uploading atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		downloading.Add(1)
+		defer downloading.Add(-1)
+		chunk := make([]byte, 32*1024)
+		for r.Context().Err() == nil {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	})
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		uploading.Add(1)
+		defer uploading.Add(-1)
+		io.Copy(io.Discard, r.Body)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	db, err := initDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	config := BufferbloatConfig{
+		DownloadURL: server.URL + "/download",
+		UploadURL:   server.URL + "/upload",
+		Duration:    200 * time.Millisecond,
+		Streams:     2,
+	}
+	runner := newBufferbloatRunner(db, config, TargetConfig{Name: "isp", Host: "192.0.2.1"})
+
+	// Latency grows by 20 ms under download and 250 ms under upload
+	runner.ping = func(duration time.Duration) *PingStats {
+		time.Sleep(duration)
+		latency := 10.0
+		if downloading.Load() > 0 {
+			latency += 20
+		}
+		if uploading.Load() > 0 {
+			latency += 250
+		}
+		return &PingStats{Avg: &latency}
+	}
+
+	result, err := runner.Run()
+	if err != nil {
+		t.Fatalf("Bufferbloat test failed: %v", err)
+	}
+
+	if result.IdleLatency == nil || *result.IdleLatency != 10 {
+		t.Errorf("Expected idle latency 10, got %v", result.IdleLatency)
+	}
+	if result.DownloadDelta == nil || *result.DownloadDelta != 20 {
+		t.Errorf("Expected download delta 20, got %v", result.DownloadDelta)
+	}
+	if result.UploadDelta == nil || *result.UploadDelta != 250 {
+		t.Errorf("Expected upload delta 250, got %v", result.UploadDelta)
+	}
+	if result.Grade != "D" {
+		t.Errorf("Expected grade D from the upload delta, got %s", result.Grade)
+	}
+	if result.DownloadMbps <= 0 || result.UploadMbps <= 0 {
+		t.Errorf("Expected throughput in both directions, got down=%.1f up=%.1f",
+			result.DownloadMbps, result.UploadMbps)
+	}
+
+	history, err := getBufferbloatHistory(db, "isp", 10)
+	if err != nil {
+		t.Fatalf("Failed to get bufferbloat history: %v", err)
+	}
+	if len(history) != 1 || history[0].Grade != "D" {
+		t.Errorf("Expected stored result with grade D, got %+v", history)
+	}
+}
+
+func TestBufferbloatRunLoadFailed(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusNotFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	db, err := initDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	// Neither an error response nor an unreachable endpoint loads the link,
+	// so the latency barely moves and would otherwise grade A+
+	for _, config := range []BufferbloatConfig{
+		{DownloadURL: server.URL + "/download"},
+		{UploadURL: "http://127.0.0.1:1/upload"},
+	} {
+		config.Duration = 100 * time.Millisecond
+		runner := newBufferbloatRunner(db, config, TargetConfig{Name: "isp", Host: "192.0.2.1"})
+		runner.ping = func(duration time.Duration) *PingStats {
+			time.Sleep(duration)
+			return &PingStats{Avg: float64Ptr(10)}
+		}
+		if _, err := runner.Run(); err == nil || !strings.Contains(err.Error(), "phase failed") {
+			t.Errorf("%+v: expected the phase to fail, got %v", config, err)
+		}
+	}
+
+	history, err := getBufferbloatHistory(db, "isp", 10)
+	if err != nil {
+		t.Fatalf("Failed to get bufferbloat history: %v", err)
+	}
+	if len(history) != 0 {
+		t.Errorf("Expected no stored result, got %+v", history)
+	}
+}
+
+func TestBufferbloatRunNoReplies(t *testing.T) {
+	db, err := initDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	runner := newBufferbloatRunner(db, BufferbloatConfig{DownloadURL: "http://127.0.0.1:1/"}, TargetConfig{Name: "isp"})
+	runner.ping = func(time.Duration) *PingStats {
+		return &PingStats{PacketLoss: 100}
+	}
+
+	if _, err := runner.Run(); err == nil {
+		t.Error("Expected error when the target doesn't reply while idle, got nil")
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Default API endpoints for notifiers whose url setting is optional.
+const (
+	defaultTelegramURL = "https://api.telegram.org"
+	defaultNtfyURL     = "https://ntfy.sh"
+)
+
+var chatClient = &http.Client{Timeout: 30 * time.Second}
+
+// postJSON sends payload to endpoint and treats any non-2xx response as an
+// error. Errors leave out the URL: webhook URLs and Telegram's bot path
+// carry credentials, and the errors end up in the log.
+func postJSON(endpoint string, payload any, header http.Header) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return redactURL(err)
+	}
+	req.Header = header.Clone()
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := chatClient.Do(req)
+	if err != nil {
+		return redactURL(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// redactURL strips the request URL from err, keeping the operation and cause.
+func redactURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s request failed: %v", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
+
+// alertField is one labelled value of an alert, for services that lay
+// messages out as fields.
+type alertField struct {
+	Name, Value string
+}
+
+func alertFields(a Alert) []alertField {
+	fields := []alertField{
+		{"Series", a.Series},
+		{"Started", a.Started.Format(time.RFC1123)},
+	}
+	if a.Ended != nil {
+		fields = append(fields, alertField{"Ended", fmt.Sprintf("%s (%s)", a.Ended.Format(time.RFC1123), a.Ended.Sub(a.Started).Round(time.Second))})
+	}
+	return append(fields,
+		alertField{"Rounds", fmt.Sprint(a.Summary.Rounds)},
+		alertField{"Packet loss", fmt.Sprintf("%.1f%%", a.Summary.PacketLoss)},
+		alertField{"Availability", fmt.Sprintf("%.1f%%", a.Summary.Availability)},
+		alertField{"Latency", formatLatency(a.Summary)},
+	)
+}
+
+// slackNotifier posts to a Slack incoming webhook using Block Kit.
+type slackNotifier struct {
+	url string
+}
+
+func (n *slackNotifier) Notify(a Alert) error {
+	var fields []map[string]string
+	for _, f := range alertFields(a) {
+		fields = append(fields, map[string]string{"type": "mrkdwn", "text": fmt.Sprintf("*%s*\n%s", f.Name, f.Value)})
+	}
+	return postJSON(n.url, map[string]any{
+		"text": alertSubject(a), // Shown in notifications
+		"blocks": []any{
+			map[string]any{"type": "header", "text": map[string]string{"type": "plain_text", "text": alertSubject(a)}},
+			map[string]any{"type": "section", "fields": fields},
+		},
+	}, nil)
+}
+
+// Discord embed colors.
+const (
+	discordRed   = 0xE74C3C
+	discordGreen = 0x2ECC71
+)
+
+// discordNotifier posts to a Discord webhook as an embed.
+type discordNotifier struct {
+	url string
+}
+
+func (n *discordNotifier) Notify(a Alert) error {
+	color := discordRed
+	if a.Status == AlertResolved {
+		color = discordGreen
+	}
+	var fields []map[string]any
+	for _, f := range alertFields(a) {
+		fields = append(fields, map[string]any{"name": f.Name, "value": f.Value, "inline": true})
+	}
+	return postJSON(n.url, map[string]any{
+		"embeds": []any{map[string]any{
+			"title":       alertSubject(a),
+			"description": a.Series + " " + a.Condition,
+			"color":       color,
+			"fields":      fields,
+			"timestamp":   a.Started.Format(time.RFC3339),
+		}},
+	}, nil)
+}
+
+// telegramNotifier sends a message through the Telegram Bot API.
+type telegramNotifier struct {
+	url    string
+	token  string
+	chatID string
+}
+
+func (n *telegramNotifier) Notify(a Alert) error {
+	return postJSON(fmt.Sprintf("%s/bot%s/sendMessage", n.url, n.token), map[string]string{
+		"chat_id": n.chatID,
+		"text":    alertSubject(a) + "\n\n" + alertText(a),
+	}, nil)
+}
+
+// ntfyNotifier publishes to an ntfy topic. Firing alerts use the configured
+// priority; resolved alerts use ntfy's default.
+type ntfyNotifier struct {
+	url      string
+	topic    string
+	token    string
+	priority int
+}
+
+func (n *ntfyNotifier) Notify(a Alert) error {
+	message := map[string]any{
+		"topic":   n.topic,
+		"title":   alertSubject(a),
+		"message": alertText(a),
+		"tags":    []string{"warning"},
+	}
+	if a.Status == AlertResolved {
+		message["tags"] = []string{"white_check_mark"}
+	} else if n.priority > 0 {
+		message["priority"] = n.priority
+	}
+
+	header := make(http.Header)
+	if n.token != "" {
+		header.Set("Authorization", "Bearer "+n.token)
+	}
+	return postJSON(n.url, message, header)
+}
+
+// gotifyNotifier sends a message to a Gotify server. Firing alerts use the
+// configured priority; resolved alerts use the application's default.
+type gotifyNotifier struct {
+	url      string
+	token    string
+	priority int
+}
+
+func (n *gotifyNotifier) Notify(a Alert) error {
+	message := map[string]any{
+		"title":   alertSubject(a),
+		"message": alertText(a),
+	}
+	if a.Status == AlertFiring && n.priority > 0 {
+		message["priority"] = n.priority
+	}
+
+	header := make(http.Header)
+	header.Set("X-Gotify-Key", n.token)
+	return postJSON(n.url+"/message", message, header)
+}
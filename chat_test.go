@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type chatRequest struct {
+	path   string
+	header http.Header
+	body   map[string]any
+}
+
+// chatStandIn records the requests notifiers make in place of the real
+// services.
+func chatStandIn(t *testing.T) (*httptest.Server, *[]chatRequest) {
+	t.Helper()
+
+	var requests []chatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		request := chatRequest{path: r.URL.Path, header: r.Header}
+		if err := json.Unmarshal(data, &request.body); err != nil {
+			t.Errorf("%s: invalid JSON: %v", r.URL.Path, err)
+		}
+		requests = append(requests, request)
+		if strings.HasSuffix(r.URL.Path, "/fail") {
+			http.Error(w, "invalid_token", http.StatusForbidden)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func testAlert(status string) Alert {
+	started := time.Date(2025, 10, 19, 3, 0, 0, 0, time.UTC)
+	alert := Alert{
+		Rule:      "isp-down",
+		Status:    status,
+		Series:    "isp/IPv4",
+		Condition: "packet loss above 50.0%",
+		Started:   started,
+		Summary:   RoundsSummary{Rounds: 3, Availability: 66.7, PacketLoss: 60, Avg: float64Ptr(25), P95: float64Ptr(40)},
+	}
+	if status == AlertResolved {
+		ended := started.Add(5 * time.Minute)
+		alert.Ended = &ended
+	}
+	return alert
+}
+
+func TestChatNotifiers(t *testing.T) {
+	server, requests := chatStandIn(t)
+
+	notifiers := newNotifiers([]NotifierConfig{
+		{Name: "slack", Type: NotifierSlack, URL: server.URL + "/services/T0/B0/X"},
+		{Name: "discord", Type: NotifierDiscord, URL: server.URL + "/api/webhooks/1/abc"},
+		{Name: "telegram", Type: NotifierTelegram, URL: server.URL, Token: "123:abc", ChatID: "-100"},
+		{Name: "ntfy", Type: NotifierNtfy, URL: server.URL + "/", Topic: "pingo", Token: "tk_secret", Priority: 5},
+		{Name: "gotify", Type: NotifierGotify, URL: server.URL, Token: "app-token", Priority: 8},
+	})
+
+	for _, name := range []string{"slack", "discord", "telegram", "ntfy", "gotify"} {
+		if err := notifiers[name].Notify(testAlert(AlertFiring)); err != nil {
+			t.Fatalf("%s: failed to notify: %v", name, err)
+		}
+	}
+	if len(*requests) != 5 {
+		t.Fatalf("Expected 5 requests, got %d", len(*requests))
+	}
+	slack, discord, telegram, ntfy, gotify := (*requests)[0], (*requests)[1], (*requests)[2], (*requests)[3], (*requests)[4]
+
+	if slack.body["text"] != "[FIRING] isp-down: isp/IPv4 packet loss above 50.0%" {
+		t.Errorf("Slack: unexpected text %v", slack.body["text"])
+	}
+	blocks := slack.body["blocks"].([]any)
+	fields := blocks[1].(map[string]any)["fields"].([]any)
+	if fields[0].(map[string]any)["text"] != "*Series*\nisp/IPv4" {
+		t.Errorf("Slack: unexpected first field %v", fields[0])
+	}
+
+	embed := discord.body["embeds"].([]any)[0].(map[string]any)
+	if embed["color"] != float64(discordRed) || embed["timestamp"] != "2025-10-19T03:00:00Z" {
+		t.Errorf("Discord: unexpected embed %v", embed)
+	}
+
+	if telegram.path != "/bot123:abc/sendMessage" || telegram.body["chat_id"] != "-100" {
+		t.Errorf("Telegram: unexpected request to %s: %v", telegram.path, telegram.body)
+	}
+	if text, _ := telegram.body["text"].(string); !strings.Contains(text, "Packet loss: 60.0%") {
+		t.Errorf("Telegram: expected a summary, got %q", text)
+	}
+
+	if ntfy.path != "/" || ntfy.body["topic"] != "pingo" || ntfy.body["priority"] != float64(5) {
+		t.Errorf("ntfy: unexpected request to %s: %v", ntfy.path, ntfy.body)
+	}
+	if ntfy.header.Get("Authorization") != "Bearer tk_secret" {
+		t.Errorf("ntfy: expected the access token, got %q", ntfy.header.Get("Authorization"))
+	}
+
+	if gotify.path != "/message" || gotify.header.Get("X-Gotify-Key") != "app-token" || gotify.body["priority"] != float64(8) {
+		t.Errorf("Gotify: unexpected request to %s: %v", gotify.path, gotify.body)
+	}
+
+	// Resolved alerts are marked as such and don't escalate
+	*requests = nil
+	for _, name := range []string{"discord", "ntfy"} {
+		if err := notifiers[name].Notify(testAlert(AlertResolved)); err != nil {
+			t.Fatalf("%s: failed to notify: %v", name, err)
+		}
+	}
+	if embed := (*requests)[0].body["embeds"].([]any)[0].(map[string]any); embed["color"] != float64(discordGreen) {
+		t.Errorf("Discord: expected green for resolved, got %v", embed["color"])
+	}
+	if _, ok := (*requests)[1].body["priority"]; ok {
+		t.Errorf("ntfy: expected the default priority for resolved, got %v", (*requests)[1].body)
+	}
+}
+
+func TestChatNotifierErrors(t *testing.T) {
+	server, _ := chatStandIn(t)
+
+	notifier := &slackNotifier{url: server.URL + "/fail"}
+	err := notifier.Notify(testAlert(AlertFiring))
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "invalid_token") {
+		t.Errorf("Expected the service's error, got %v", err)
+	}
+}
+
+func TestChatNotifierErrorsHideToken(t *testing.T) {
+	server, _ := chatStandIn(t)
+	server.Close()
+
+	notifier := &telegramNotifier{url: server.URL, token: "123:secret", chatID: "-100"}
+	err := notifier.Notify(testAlert(AlertFiring))
+	if err == nil {
+		t.Fatal("Expected an error from a closed server")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("Expected the token to be left out of the error, got %v", err)
+	}
+
+	// A URL that doesn't parse is quoted by net/url too
+	notifier.url = "http://[::1"
+	if err := notifier.Notify(testAlert(AlertFiring)); err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("Expected an error without the token, got %v", err)
+	}
+}
+
+func TestChatNotifierDefaults(t *testing.T) {
+	notifiers := newNotifiers([]NotifierConfig{
+		{Name: "telegram", Type: NotifierTelegram, Token: "123:abc", ChatID: "-100"},
+		{Name: "ntfy", Type: NotifierNtfy, Topic: "pingo"},
+	})
+	if url := notifiers["telegram"].(*telegramNotifier).url; url != defaultTelegramURL {
+		t.Errorf("Expected Telegram to default to %s, got %s", defaultTelegramURL, url)
+	}
+	if url := notifiers["ntfy"].(*ntfyNotifier).url; url != defaultNtfyURL {
+		t.Errorf("Expected ntfy to default to %s, got %s", defaultNtfyURL, url)
+	}
+
+	for _, c := range []NotifierConfig{
+		{Type: NotifierSlack},
+		{Type: NotifierTelegram, Token: "123:abc"},
+		{Type: NotifierNtfy, Topic: "pingo", Priority: 6},
+		{Type: NotifierGotify, URL: "https://gotify.example.com"},
+	} {
+		if err := c.validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", c)
+		}
+	}
+}
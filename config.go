@@ -5,16 +5,302 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
 
 type Config struct {
-	Port          string `toml:"port"`
-	Target        string `toml:"target"`
+	Port    string         `toml:"port"`
+	Target  string         `toml:"target"`
+	Family  string         `toml:"family"`
+	Targets []TargetConfig `toml:"targets"`
+	ProbeOptions
+	PMTUOptions
 	PingCount     int    `toml:"ping_count"`
 	RetentionDays int    `toml:"retention_days"`
 	DBPath        string `toml:"db_path"`
+
+	Listen              []string      `toml:"listen"`               // Addresses to serve on, e.g. "127.0.0.1:7777" or "unix:/run/pingo.sock"
+	SocketMode          int           `toml:"socket_mode"`          // Permissions for Unix sockets (default 0o660)
+	JournalMode         string        `toml:"journal_mode"`         // SQLite journal mode (default "wal")
+	Synchronous         string        `toml:"synchronous"`          // SQLite synchronous level (default "normal")
+	CommitInterval      time.Duration `toml:"commit_interval"`      // Buffer rounds and commit in batches, 0 to commit each round
+	MaxDBSizeMB         int           `toml:"max_db_size_mb"`       // Trim oldest data beyond this size, 0 for no cap
+	MaintenanceInterval time.Duration `toml:"maintenance_interval"` // How often to prune expired data
+	IncrementalVacuum   bool          `toml:"incremental_vacuum"`   // Return freed pages to the file system
+	BackupInterval      time.Duration `toml:"backup_interval"`      // How often to back up the database, 0 to disable
+	BackupKeep          int           `toml:"backup_keep"`          // Number of scheduled backups to keep
+	BackupDir           string        `toml:"backup_dir"`           // Where scheduled backups go (default: "backups" next to the database)
+	ReadyRounds         int           `toml:"ready_rounds"`         // Round intervals a series may go without saving before /readyz fails
+
+	Bufferbloat BufferbloatConfig `toml:"bufferbloat"`
+	Heartbeat   HeartbeatConfig   `toml:"heartbeat"`
+	Anomaly     AnomalyConfig     `toml:"anomaly"`
+
+	Alerts                []AlertRule         `toml:"alerts"`
+	Notifiers             []NotifierConfig    `toml:"notifiers"`
+	Maintenance           []MaintenanceWindow `toml:"maintenance"`
+	SLAExcludeMaintenance bool                `toml:"sla_exclude_maintenance"` // Leave silenced periods out of availability summaries
+}
+
+// TargetConfig describes a single monitored host. Name identifies the
+// target's series in the database and defaults to Host.
+type TargetConfig struct {
+	Name   string `toml:"name"`
+	Host   string `toml:"host"`
+	Family string `toml:"family"` // "", "4", "6" or "both"
+	ProbeOptions
+	PMTUOptions
+}
+
+// PMTUOptions control path MTU discovery for a target. Discovery is
+// disabled unless an interval is set.
+type PMTUOptions struct {
+	PMTUInterval time.Duration `toml:"pmtu_interval"` // How often to run discovery, e.g. "1h"
+	PMTUMax      int           `toml:"pmtu_max"`      // Largest MTU to try (default 1500)
+}
+
+// ProbeOptions are the per-packet settings passed to ping. Zero values
+// leave the ping command's defaults in place. They are recorded with every
+// round so results taken with different settings can be told apart.
+type ProbeOptions struct {
+	Size         int    `toml:"size" json:"size"`                   // ICMP payload size in bytes
+	DontFragment bool   `toml:"dont_fragment" json:"dont_fragment"` // Set the DF bit (IPv4) / disable fragmentation (IPv6)
+	TTL          int    `toml:"ttl" json:"ttl"`                     // IPv4 TTL / IPv6 hop limit
+	TOS          int    `toml:"tos" json:"tos"`                     // Full TOS / traffic class byte
+	DSCP         int    `toml:"dscp" json:"-"`                      // Convenience for TOS = DSCP << 2
+	Source       string `toml:"source" json:"source"`               // Source address or interface name
+}
+
+// normalized folds DSCP into the TOS byte so only TOS needs to be passed on.
+func (o ProbeOptions) normalized() ProbeOptions {
+	if o.DSCP != 0 {
+		o.TOS = o.DSCP << 2
+		o.DSCP = 0
+	}
+	return o
+}
+
+func (o ProbeOptions) validate() error {
+	if o.Size < 0 || o.Size > 65507 {
+		return fmt.Errorf("invalid size %d: must be between 0 and 65507", o.Size)
+	}
+	if o.TTL < 0 || o.TTL > 255 {
+		return fmt.Errorf("invalid ttl %d: must be between 0 and 255", o.TTL)
+	}
+	if o.TOS < 0 || o.TOS > 255 {
+		return fmt.Errorf("invalid tos %d: must be between 0 and 255", o.TOS)
+	}
+	if o.DSCP < 0 || o.DSCP > 63 {
+		return fmt.Errorf("invalid dscp %d: must be between 0 and 63", o.DSCP)
+	}
+	if o.DSCP != 0 && o.TOS != 0 {
+		return fmt.Errorf("dscp and tos are mutually exclusive")
+	}
+	if o.Source != "" {
+		if err := validateSource(o.Source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Address family settings for a target. An empty family leaves the choice
+// to the system ping command.
+const (
+	FamilyAny  = ""
+	FamilyIPv4 = "4"
+	FamilyIPv6 = "6"
+	FamilyBoth = "both"
+)
+
+func validateFamily(family string) error {
+	switch family {
+	case FamilyAny, FamilyIPv4, FamilyIPv6, FamilyBoth:
+		return nil
+	}
+	return fmt.Errorf("invalid family %q: must be \"4\", \"6\" or \"both\"", family)
+}
+
+// monitoredTargets returns the targets to probe. When no [[targets]] are
+// configured, the top-level target and family settings are used.
+func (c Config) monitoredTargets() []TargetConfig {
+	if len(c.Targets) == 0 {
+		return []TargetConfig{{
+			Name:         c.Target,
+			Host:         c.Target,
+			Family:       c.Family,
+			ProbeOptions: c.ProbeOptions.normalized(),
+			PMTUOptions:  c.PMTUOptions,
+		}}
+	}
+
+	targets := make([]TargetConfig, len(c.Targets))
+	for i, t := range c.Targets {
+		if t.Name == "" {
+			t.Name = t.Host
+		}
+		t.ProbeOptions = t.ProbeOptions.normalized()
+		targets[i] = t
+	}
+	return targets
+}
+
+// validate checks settings that can't be expressed through TOML types alone.
+func (c Config) validate() error {
+	if err := c.ProbeOptions.validate(); err != nil {
+		return err
+	}
+	for _, t := range c.Targets {
+		if err := t.ProbeOptions.validate(); err != nil {
+			return fmt.Errorf("target %q: %v", t.Host, err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, t := range c.monitoredTargets() {
+		if t.Host == "" {
+			return fmt.Errorf("target %q has no host", t.Name)
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("duplicate target name %q", t.Name)
+		}
+		seen[t.Name] = true
+		if err := validateFamily(t.Family); err != nil {
+			return fmt.Errorf("target %q: %v", t.Name, err)
+		}
+		if t.PMTUInterval < 0 {
+			return fmt.Errorf("target %q: pmtu_interval must not be negative", t.Name)
+		}
+		if t.PMTUMax != 0 && (t.PMTUMax < minPMTU || t.PMTUMax > 65535) {
+			return fmt.Errorf("target %q: pmtu_max must be between %d and 65535", t.Name, minPMTU)
+		}
+	}
+
+	for _, address := range c.Listen {
+		if err := validateListenAddress(address); err != nil {
+			return err
+		}
+	}
+	if c.SocketMode < 0 || c.SocketMode > 0o777 {
+		return fmt.Errorf("invalid socket_mode %#o: must be between 0 and 0o777", c.SocketMode)
+	}
+
+	if err := c.dbOptions().validate(); err != nil {
+		return err
+	}
+	if c.CommitInterval < 0 {
+		return fmt.Errorf("commit_interval must not be negative")
+	}
+	if c.MaxDBSizeMB < 0 {
+		return fmt.Errorf("max_db_size_mb must not be negative")
+	}
+	if c.MaintenanceInterval <= 0 {
+		return fmt.Errorf("maintenance_interval must be positive")
+	}
+	if c.BackupInterval < 0 {
+		return fmt.Errorf("backup_interval must not be negative")
+	}
+	if c.BackupKeep < 1 {
+		return fmt.Errorf("backup_keep must be at least 1")
+	}
+	if c.ReadyRounds < 1 {
+		return fmt.Errorf("ready_rounds must be at least 1")
+	}
+
+	if c.Bufferbloat.Target != "" && !seen[c.Bufferbloat.Target] {
+		return fmt.Errorf("bufferbloat: unknown target %q", c.Bufferbloat.Target)
+	}
+	if c.Bufferbloat.Interval < 0 || c.Bufferbloat.Duration < 0 {
+		return fmt.Errorf("bufferbloat: interval and duration must not be negative")
+	}
+
+	if c.Heartbeat.Target != "" && !seen[c.Heartbeat.Target] {
+		return fmt.Errorf("heartbeat: unknown target %q", c.Heartbeat.Target)
+	}
+	if err := c.Heartbeat.validate(); err != nil {
+		return fmt.Errorf("heartbeat: %v", err)
+	}
+	if err := c.Anomaly.validate(); err != nil {
+		return fmt.Errorf("anomaly: %v", err)
+	}
+
+	notifiers := make(map[string]bool)
+	for _, n := range c.Notifiers {
+		if n.Name == "" {
+			return fmt.Errorf("notifier has no name")
+		}
+		if notifiers[n.Name] {
+			return fmt.Errorf("duplicate notifier name %q", n.Name)
+		}
+		notifiers[n.Name] = true
+		if err := n.validate(); err != nil {
+			return fmt.Errorf("notifier %q: %v", n.Name, err)
+		}
+	}
+	rules := make(map[string]bool)
+	for _, r := range c.Alerts {
+		if r.Name == "" {
+			return fmt.Errorf("alert has no name")
+		}
+		if rules[r.Name] {
+			return fmt.Errorf("duplicate alert name %q", r.Name)
+		}
+		rules[r.Name] = true
+		if err := r.validate(seen, notifiers); err != nil {
+			return fmt.Errorf("alert %q: %v", r.Name, err)
+		}
+	}
+	for _, w := range c.Maintenance {
+		if err := w.validate(seen, rules); err != nil {
+			return fmt.Errorf("maintenance window %q: %v", w.Name, err)
+		}
+	}
+	return nil
+}
+
+func (c Config) dbOptions() DBOptions {
+	return DBOptions{JournalMode: c.JournalMode, Synchronous: c.Synchronous}
+}
+
+// maxDBBytes returns the database size cap in bytes, or 0 for no cap.
+func (c Config) maxDBBytes() int64 {
+	return int64(c.MaxDBSizeMB) << 20
+}
+
+// listenAddresses returns where the web server listens. Without a listen
+// setting it serves on every interface at the configured port.
+func (c Config) listenAddresses() []string {
+	if len(c.Listen) > 0 {
+		return c.Listen
+	}
+	return []string{":" + c.Port}
+}
+
+// readyMaxAge returns how long a series may go without saving a round
+// before the monitor counts as stuck.
+func (c Config) readyMaxAge() time.Duration {
+	return time.Duration(c.ReadyRounds) * roundInterval(c.PingCount)
+}
+
+// backupDir returns the directory scheduled backups are written to.
+func (c Config) backupDir() string {
+	if c.BackupDir != "" {
+		return c.BackupDir
+	}
+	return filepath.Join(filepath.Dir(c.DBPath), "backups")
+}
+
+// findTarget returns the target with the given name, or the first target
+// when name is empty.
+func findTarget(targets []TargetConfig, name string) (TargetConfig, bool) {
+	for _, t := range targets {
+		if name == "" || t.Name == name {
+			return t, true
+		}
+	}
+	return TargetConfig{}, false
 }
 
 func getDefaultDataDir() string {
@@ -75,13 +361,20 @@ func getDefaultDBPath() string {
 }
 
 func getDefaultConfig() Config {
-    return Config{
-        Port:          "7777",
-        Target:        "8.8.8.8",
-        PingCount:     5,
-        RetentionDays: 15,
-        DBPath:        getDefaultDBPath(),
-    }
+	return Config{
+		Port:          "7777",
+		Target:        "8.8.8.8",
+		PingCount:     5,
+		RetentionDays: 15,
+		DBPath:        getDefaultDBPath(),
+
+		SocketMode:          0o660,
+		JournalMode:         defaultDBOptions.JournalMode,
+		Synchronous:         defaultDBOptions.Synchronous,
+		MaintenanceInterval: time.Hour,
+		BackupKeep:          7,
+		ReadyRounds:         3,
+	}
 }
 
 func loadConfig(configPath string) (Config, error) {
@@ -98,6 +391,10 @@ func loadConfig(configPath string) (Config, error) {
 		return config, fmt.Errorf("failed to parse config file: %v", err)
 	}
 
+	if err := config.validate(); err != nil {
+		return config, fmt.Errorf("invalid config file: %v", err)
+	}
+
 	log.Printf("Loaded config from %s", configPath)
 	return config, nil
 }
@@ -7,7 +7,7 @@ import (
 )
 
 func TestGetDefaultConfig(t *testing.T) {
-    config := getDefaultConfig()
+	config := getDefaultConfig()
 
 	if config.Port != "7777" {
 		t.Errorf("Expected default port 7777, got %s", config.Port)
@@ -15,12 +15,12 @@ func TestGetDefaultConfig(t *testing.T) {
 	if config.Target != "8.8.8.8" {
 		t.Errorf("Expected default target 8.8.8.8, got %s", config.Target)
 	}
-    if config.PingCount != 5 {
-        t.Errorf("Expected default ping count 5, got %d", config.PingCount)
-    }
-    if config.RetentionDays != 15 {
-        t.Errorf("Expected default retention days 15, got %d", config.RetentionDays)
-    }
+	if config.PingCount != 5 {
+		t.Errorf("Expected default ping count 5, got %d", config.PingCount)
+	}
+	if config.RetentionDays != 15 {
+		t.Errorf("Expected default retention days 15, got %d", config.RetentionDays)
+	}
 }
 
 func TestLoadConfigNonExistent(t *testing.T) {
@@ -95,3 +95,68 @@ invalid toml syntax
 		t.Error("Expected error for invalid config file, got nil")
 	}
 }
+
+func TestLoadConfigTargets(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.toml")
+
+	configContent := `
+[[targets]]
+host = "1.1.1.1"
+
+[[targets]]
+name = "isp"
+host = "example.com"
+family = "both"
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	targets := config.monitoredTargets()
+	if len(targets) != 2 {
+		t.Fatalf("Expected 2 targets, got %d", len(targets))
+	}
+	if targets[0].Name != "1.1.1.1" {
+		t.Errorf("Expected unnamed target to default to its host, got %s", targets[0].Name)
+	}
+	if targets[1].Name != "isp" || targets[1].Family != FamilyBoth {
+		t.Errorf("Expected isp target with family both, got %+v", targets[1])
+	}
+}
+
+func TestLoadConfigInvalidFamily(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.toml")
+
+	if err := os.WriteFile(configPath, []byte(`family = "5"`), 0644); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+
+	if _, err := loadConfig(configPath); err == nil {
+		t.Error("Expected error for invalid family, got nil")
+	}
+}
+
+func TestProbeOptionsDSCP(t *testing.T) {
+	config := getDefaultConfig()
+	config.DSCP = 46
+
+	if err := config.validate(); err != nil {
+		t.Fatalf("Expected dscp 46 to be valid, got %v", err)
+	}
+	if tos := config.monitoredTargets()[0].TOS; tos != 184 {
+		t.Errorf("Expected dscp 46 to map to tos 184, got %d", tos)
+	}
+
+	config.TOS = 184
+	if err := config.validate(); err == nil {
+		t.Error("Expected error when both dscp and tos are set, got nil")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronSchedule(t *testing.T) {
+	at := func(s string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		spec  string
+		time  string
+		match bool
+	}{
+		{"* * * * *", "2025-10-19 03:17", true},
+		{"0 2 * * 0", "2025-10-19 02:00", true}, // A Sunday
+		{"0 2 * * 7", "2025-10-19 02:00", true}, // 7 is Sunday too
+		{"0 2 * * 0", "2025-10-20 02:00", false},
+		{"0 2 * * 0", "2025-10-19 02:01", false},
+		{"*/15 * * * *", "2025-10-19 03:45", true},
+		{"*/15 * * * *", "2025-10-19 03:46", false},
+		{"5/20 * * * *", "2025-10-19 03:45", true},
+		{"0 1-5 * * *", "2025-10-19 05:00", true},
+		{"0 1-5 * * *", "2025-10-19 06:00", false},
+		{"30 1 1,15 * *", "2025-10-15 01:30", true},
+		{"0 0 * 1-3 *", "2025-10-01 00:00", false},
+		// With both day fields restricted, either one matching is enough
+		{"0 3 1 * 0", "2025-10-19 03:00", true},
+		{"0 3 1 * 0", "2025-10-01 03:00", true},
+		{"0 3 1 * 0", "2025-10-02 03:00", false},
+	}
+
+	for _, tt := range tests {
+		c, err := parseCron(tt.spec)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.spec, err)
+			continue
+		}
+		if got := c.matches(at(tt.time)); got != tt.match {
+			t.Errorf("%q at %s: expected %v, got %v", tt.spec, tt.time, tt.match, got)
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "mon * * * *", "* * * * 8"} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+// dayLayout formats days in daily summaries. Days follow the server's
+// timezone, so a calendar view lines up with local midnight.
+const dayLayout = "2006-01-02"
+
+// RoundsSummary aggregates a set of rounds.
+type RoundsSummary struct {
+	Rounds       int      `json:"rounds"`
+	Availability float64  `json:"availability"` // Percentage of rounds with at least one reply
+	PacketLoss   float64  `json:"packet_loss"`  // Mean packet loss percentage
+	Avg          *float64 `json:"avg"`          // Mean of the rounds' average latency
+	P95          *float64 `json:"p95"`          // 95th percentile of the rounds' average latency
+}
+
+// DailySummary aggregates one series' rounds over a day.
+type DailySummary struct {
+	Day    string `json:"day"` // YYYY-MM-DD in the server's timezone
+	Target string `json:"target"`
+	Family string `json:"family"`
+	RoundsSummary
+	WorstHour     *int     `json:"worst_hour"`      // Local hour (0-23) with the most loss, then the highest latency
+	WorstHourLoss *float64 `json:"worst_hour_loss"` // Mean packet loss during the worst hour
+	WorstHourAvg  *float64 `json:"worst_hour_avg"`  // Mean latency during the worst hour
+
+	replies int // Rounds with a latency, behind Avg
+}
+
+// dayBounds returns the start of the day containing t and of the next day,
+// in the server's timezone. Days are 23 or 25 hours long across DST changes.
+func dayBounds(t time.Time) (time.Time, time.Time) {
+	local := t.In(time.Local)
+	start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.Local)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// percentile returns the nearest-rank percentile p (0-100) of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+func summarizeRounds(rounds []PingStats) RoundsSummary {
+	summary := RoundsSummary{Rounds: len(rounds)}
+	if len(rounds) == 0 {
+		return summary
+	}
+
+	var latencies []float64
+	available := 0
+	for _, r := range rounds {
+		summary.PacketLoss += r.PacketLoss
+		if r.PacketLoss < 100 {
+			available++
+		}
+		if r.Avg != nil {
+			latencies = append(latencies, *r.Avg)
+		}
+	}
+	summary.PacketLoss /= float64(len(rounds))
+	summary.Availability = float64(available) / float64(len(rounds)) * 100
+
+	if len(latencies) > 0 {
+		sum := 0.0
+		for _, l := range latencies {
+			sum += l
+		}
+		avg := sum / float64(len(latencies))
+		sort.Float64s(latencies)
+		p95 := percentile(latencies, 95)
+		summary.Avg, summary.P95 = &avg, &p95
+	}
+	return summary
+}
+
+// summarizeDay aggregates a series' rounds for one day.
+func summarizeDay(day, target, family string, rounds []PingStats) DailySummary {
+	summary := DailySummary{Day: day, Target: target, Family: family, RoundsSummary: summarizeRounds(rounds)}
+	if len(rounds) == 0 {
+		return summary
+	}
+
+	type hourTotals struct {
+		rounds, replies  int
+		loss, latencySum float64
+	}
+	var hours [24]hourTotals
+	for _, r := range rounds {
+		h := &hours[r.Timestamp.In(time.Local).Hour()]
+		h.rounds++
+		h.loss += r.PacketLoss
+		if r.Avg != nil {
+			h.replies++
+			h.latencySum += *r.Avg
+			summary.replies++
+		}
+	}
+
+	worst, worstLoss, worstAvg := -1, 0.0, 0.0
+	for hour, h := range hours {
+		if h.rounds == 0 {
+			continue
+		}
+		loss := h.loss / float64(h.rounds)
+		avg := 0.0
+		if h.replies > 0 {
+			avg = h.latencySum / float64(h.replies)
+		}
+		if worst < 0 || loss > worstLoss || (loss == worstLoss && avg > worstAvg) {
+			worst, worstLoss, worstAvg = hour, loss, avg
+		}
+	}
+	summary.WorstHour, summary.WorstHourLoss = &worst, &worstLoss
+	if hours[worst].replies > 0 {
+		summary.WorstHourAvg = &worstAvg
+	}
+	return summary
+}
+
+const upsertDailySummarySQL = `INSERT INTO daily_summary (target, family, day, rounds, availability, packet_loss,
+	avg, replies, p95, worst_hour, worst_hour_loss, worst_hour_avg) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (target, family, day) DO UPDATE SET rounds = excluded.rounds,
+	availability = excluded.availability, packet_loss = excluded.packet_loss, avg = excluded.avg,
+	replies = excluded.replies, p95 = excluded.p95, worst_hour = excluded.worst_hour,
+	worst_hour_loss = excluded.worst_hour_loss, worst_hour_avg = excluded.worst_hour_avg`
+
+// addToDailySummarySQL folds one round into its day's running means. The
+// SET expressions all see the row as it was before the update.
+const addToDailySummarySQL = `INSERT INTO daily_summary (target, family, day, rounds, availability, packet_loss,
+	avg, replies) VALUES (?, ?, ?, 1, ?, ?, ?, ?)
+	ON CONFLICT (target, family, day) DO UPDATE SET rounds = rounds + 1,
+	availability = (availability * rounds + excluded.availability) / (rounds + 1),
+	packet_loss = (packet_loss * rounds + excluded.packet_loss) / (rounds + 1),
+	avg = CASE WHEN excluded.avg IS NULL THEN avg
+		ELSE (COALESCE(avg, 0) * replies + excluded.avg) / (replies + 1) END,
+	replies = replies + excluded.replies`
+
+// addToDailySummary updates the round's day with the prepared
+// addToDailySummarySQL, bound to the transaction saving the round.
+func addToDailySummary(stmt *sql.Stmt, s *PingStats) error {
+	availability, replies := 0.0, 0
+	if s.PacketLoss < 100 {
+		availability = 100
+	}
+	if s.Avg != nil {
+		replies = 1
+	}
+	_, err := stmt.Exec(s.Target, s.Family, s.Timestamp.In(time.Local).Format(dayLayout),
+		availability, s.PacketLoss, s.Avg, replies)
+	return err
+}
+
+// dailySummaryInterval is how often the current day's summaries are
+// recomputed. Rounds, availability, loss and mean latency are updated as
+// rounds are saved; the 95th percentile and worst hour need the whole day
+// and trail by up to this long.
+const dailySummaryInterval = 5 * time.Minute
+
+// runDailySummaries keeps the current day's percentile and worst hour up
+// to date, and finishes the previous day's after midnight.
+func runDailySummaries(db *DB, interval time.Duration) {
+	last, _ := dayBounds(time.Now())
+	for {
+		time.Sleep(interval)
+		today, _ := dayBounds(time.Now())
+		if err := refreshSummariesSince(db, last); err != nil {
+			log.Printf("Failed to update daily summaries: %v", err)
+		}
+		last = today
+	}
+}
+
+// refreshSummariesSince recomputes the summaries of every series and day
+// from the day containing start up to today.
+func refreshSummariesSince(db *DB, start time.Time) error {
+	start, _ = dayBounds(start)
+	rows, err := db.Query(`SELECT DISTINCT target, family FROM ping_stats WHERE timestamp >= ?`, toEpochMillis(start))
+	if err != nil {
+		return err
+	}
+	var series []SeriesInfo
+	for rows.Next() {
+		var s SeriesInfo
+		if err := rows.Scan(&s.Target, &s.Family); err != nil {
+			rows.Close()
+			return err
+		}
+		series = append(series, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	rangeStmt := tx.Stmt(db.statsByDateRange)
+	today, _ := dayBounds(time.Now())
+	for _, s := range series {
+		for day := start; !day.After(today); day = day.AddDate(0, 0, 1) {
+			if err := refreshDailySummary(tx, rangeStmt, s.Target, s.Family, day); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// refreshDailySummary recomputes one series' summary for the day starting
+// at start. rangeStmt is the prepared date range query.
+func refreshDailySummary(tx *sql.Tx, rangeStmt *sql.Stmt, target, family string, start time.Time) error {
+	_, end := dayBounds(start)
+	rows, err := rangeStmt.Query(append(seriesFilterArgs(target, family),
+		toEpochMillis(start), toEpochMillis(end)-1)...)
+	if err != nil {
+		return err
+	}
+	rounds, err := scanPingStats(rows)
+	if err != nil {
+		return err
+	}
+
+	// An empty family matches every family in the range query
+	var series []PingStats
+	for _, r := range rounds {
+		if r.Family == family {
+			series = append(series, r)
+		}
+	}
+	if len(series) == 0 {
+		_, err := tx.Exec(`DELETE FROM daily_summary WHERE target = ? AND family = ? AND day = ?`,
+			target, family, start.Format(dayLayout))
+		return err
+	}
+
+	s := summarizeDay(start.Format(dayLayout), target, family, series)
+	_, err = tx.Exec(upsertDailySummarySQL, s.Target, s.Family, s.Day, s.Rounds, s.Availability, s.PacketLoss,
+		s.Avg, s.replies, s.P95, s.WorstHour, s.WorstHourLoss, s.WorstHourAvg)
+	return err
+}
+
+// refreshDailySummaries brings summaries up to date on startup: the
+// current day is recomputed, and days with rounds but no summary, such as
+// history from before summaries were kept, are filled in.
+func refreshDailySummaries(db *DB) error {
+	rows, err := db.Query(`SELECT target, family, MIN(timestamp) FROM ping_stats GROUP BY target, family`)
+	if err != nil {
+		return err
+	}
+	type seriesStart struct {
+		target, family string
+		first          int64
+	}
+	var series []seriesStart
+	for rows.Next() {
+		var s seriesStart
+		if err := rows.Scan(&s.target, &s.family, &s.first); err != nil {
+			rows.Close()
+			return err
+		}
+		series = append(series, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	today, _ := dayBounds(time.Now())
+	for _, s := range series {
+		summarized := make(map[string]bool)
+		days, err := db.Query(`SELECT day FROM daily_summary WHERE target = ? AND family = ?`, s.target, s.family)
+		if err != nil {
+			return err
+		}
+		for days.Next() {
+			var day string
+			if err := days.Scan(&day); err != nil {
+				days.Close()
+				return err
+			}
+			summarized[day] = true
+		}
+		days.Close()
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		rangeStmt := tx.Stmt(db.statsByDateRange)
+		filled := 0
+		for start, _ := dayBounds(fromEpochMillis(s.first)); !start.After(today); start = start.AddDate(0, 0, 1) {
+			if summarized[start.Format(dayLayout)] && !start.Equal(today) {
+				continue
+			}
+			if err := refreshDailySummary(tx, rangeStmt, s.target, s.family, start); err != nil {
+				tx.Rollback()
+				return err
+			}
+			filled++
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		if filled > 1 {
+			log.Printf("Computed %d daily summaries for %s", filled, seriesLabel(s.target, s.family))
+		}
+	}
+	return nil
+}
+
+// DailySummaries returns stored summaries for days from startDay to endDay
+// inclusive, formatted as YYYY-MM-DD, oldest first.
+func (db *DB) DailySummaries(target, family, startDay, endDay string) ([]DailySummary, error) {
+	rows, err := db.Query(`SELECT day, target, family, rounds, availability, packet_loss, avg, p95,
+		worst_hour, worst_hour_loss, worst_hour_avg FROM daily_summary
+		WHERE `+seriesFilterSQL+` AND day >= ? AND day <= ?
+		ORDER BY day ASC, target ASC, family ASC`,
+		append(seriesFilterArgs(target, family), startDay, endDay)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []DailySummary
+	for rows.Next() {
+		var s DailySummary
+		err := rows.Scan(&s.Day, &s.Target, &s.Family, &s.Rounds, &s.Availability, &s.PacketLoss, &s.Avg, &s.P95,
+			&s.WorstHour, &s.WorstHourLoss, &s.WorstHourAvg)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
+
+// DailySummaries computes summaries from the rounds held in memory.
+func (m *memStore) DailySummaries(target, family, startDay, endDay string) ([]DailySummary, error) {
+	type seriesDay struct{ day, target, family string }
+	grouped := make(map[seriesDay][]PingStats)
+	for _, s := range m.matching(target, family, func(*PingStats) bool { return true }) {
+		day := s.Timestamp.In(time.Local).Format(dayLayout)
+		if day < startDay || day > endDay {
+			continue
+		}
+		key := seriesDay{day, s.Target, s.Family}
+		grouped[key] = append(grouped[key], s)
+	}
+
+	var summaries []DailySummary
+	for key, rounds := range grouped {
+		summaries = append(summaries, summarizeDay(key.day, key.target, key.family, rounds))
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i], summaries[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		return a.Family < b.Family
+	})
+	return summaries, nil
+}
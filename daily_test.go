@@ -0,0 +1,138 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSummarizeDay(t *testing.T) {
+	day := time.Date(2025, 10, 19, 0, 0, 0, 0, time.Local)
+
+	// 20 rounds an hour apart from 02:00: latency 10-29 ms, with the round at
+	// 05:00 lost entirely and the one at 07:00 half lost
+	var rounds []PingStats
+	for i := 0; i < 20; i++ {
+		r := PingStats{Timestamp: day.Add(time.Duration(i+2) * time.Hour), Avg: float64Ptr(float64(10 + i))}
+		switch i + 2 {
+		case 5:
+			r.Avg, r.PacketLoss = nil, 100
+		case 7:
+			r.PacketLoss = 50
+		}
+		rounds = append(rounds, r)
+	}
+
+	s := summarizeDay("2025-10-19", "isp", "", rounds)
+	if s.Rounds != 20 || s.Availability != 95 || s.PacketLoss != 7.5 {
+		t.Errorf("Expected 20 rounds, 95%% availability, 7.5%% loss; got %d, %v, %v", s.Rounds, s.Availability, s.PacketLoss)
+	}
+	// Mean of 10..29 without 13
+	if s.Avg == nil || *s.Avg != float64(390-13)/19 {
+		t.Errorf("Unexpected avg %v", s.Avg)
+	}
+	// Nearest rank: ceil(0.95 * 19) = 19th of 19 values
+	if s.P95 == nil || *s.P95 != 29 {
+		t.Errorf("Expected p95 29, got %v", s.P95)
+	}
+	if s.WorstHour == nil || *s.WorstHour != 5 || *s.WorstHourLoss != 100 || s.WorstHourAvg != nil {
+		t.Errorf("Expected the fully lost 05:00 round to be the worst hour, got %v", s.WorstHour)
+	}
+}
+
+func TestDailySummaries(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			day := time.Date(2025, 10, 19, 0, 0, 0, 0, time.Local)
+			// The database adds rounds to their day as they are saved and
+			// computes the percentile on a timer; memory summarizes on demand
+			refresh := func() {
+				if db, ok := store.(*DB); ok {
+					if err := refreshSummariesSince(db, day); err != nil {
+						t.Fatalf("Failed to refresh summaries: %v", err)
+					}
+				}
+			}
+			for i := 0; i < 4; i++ {
+				err := store.SaveStats(&PingStats{Timestamp: day.Add(time.Duration(i*6) * time.Hour), Target: "isp", Avg: float64Ptr(10)})
+				if err != nil {
+					t.Fatalf("Failed to save stats: %v", err)
+				}
+			}
+			// Just after midnight belongs to the next day
+			if err := store.SaveStats(&PingStats{Timestamp: day.AddDate(0, 0, 1), Target: "isp", PacketLoss: 100}); err != nil {
+				t.Fatalf("Failed to save stats: %v", err)
+			}
+
+			summaries, err := store.DailySummaries("isp", "", "2025-10-19", "2025-10-20")
+			if err != nil {
+				t.Fatalf("Failed to get summaries: %v", err)
+			}
+			if len(summaries) != 2 {
+				t.Fatalf("Expected 2 days, got %d", len(summaries))
+			}
+			if summaries[0].Day != "2025-10-19" || summaries[0].Rounds != 4 || summaries[0].Availability != 100 ||
+				summaries[0].Avg == nil || *summaries[0].Avg != 10 {
+				t.Errorf("Unexpected first day: %+v", summaries[0])
+			}
+			if summaries[1].Rounds != 1 || summaries[1].Availability != 0 || summaries[1].Avg != nil {
+				t.Errorf("Unexpected second day: %+v", summaries[1])
+			}
+
+			// A later round updates the existing day as it is saved
+			if err := store.SaveStats(
+				&PingStats{Timestamp: day.Add(22 * time.Hour), Target: "isp", Avg: float64Ptr(20), PacketLoss: 50},
+				&PingStats{Timestamp: day.Add(23 * time.Hour), Target: "isp", PacketLoss: 100},
+			); err != nil {
+				t.Fatalf("Failed to save stats: %v", err)
+			}
+			summaries, _ = store.DailySummaries("isp", "", "2025-10-19", "2025-10-19")
+			if len(summaries) != 1 || summaries[0].Rounds != 6 || math.Abs(summaries[0].Availability-500.0/6) > 1e-9 ||
+				summaries[0].PacketLoss != 25 || summaries[0].Avg == nil || *summaries[0].Avg != 12 {
+				t.Errorf("Expected the day to be updated to 6 rounds, got %+v", summaries)
+			}
+
+			// The percentile and worst hour follow, matching a full recompute
+			refresh()
+			summaries, _ = store.DailySummaries("isp", "", "2025-10-19", "2025-10-19")
+			if len(summaries) != 1 || summaries[0].Rounds != 6 || summaries[0].P95 == nil || *summaries[0].P95 != 20 ||
+				summaries[0].WorstHour == nil || *summaries[0].WorstHour != 23 {
+				t.Errorf("Expected the percentile and worst hour, got %+v", summaries)
+			}
+			if *summaries[0].Avg != 12 || summaries[0].PacketLoss != 25 {
+				t.Errorf("Expected the recompute to agree with the running means, got %+v", summaries[0])
+			}
+		})
+	}
+}
+
+func TestRefreshDailySummariesBackfills(t *testing.T) {
+	db := testStores(t)["sqlite"].(*DB)
+
+	// History written before summaries existed
+	day := time.Date(2025, 10, 17, 12, 0, 0, 0, time.Local)
+	for i := 0; i < 3; i++ {
+		_, err := db.Exec(`INSERT INTO ping_stats (timestamp, avg, target, family) VALUES (?, ?, ?, ?)`,
+			toEpochMillis(day.AddDate(0, 0, i)), 20.0, "isp", FamilyIPv4)
+		if err != nil {
+			t.Fatalf("Failed to insert test data: %v", err)
+		}
+	}
+
+	if err := refreshDailySummaries(db); err != nil {
+		t.Fatalf("Failed to refresh summaries: %v", err)
+	}
+
+	summaries, err := db.DailySummaries("isp", FamilyIPv4, "2025-10-01", "2025-10-31")
+	if err != nil {
+		t.Fatalf("Failed to get summaries: %v", err)
+	}
+	if len(summaries) != 3 {
+		t.Fatalf("Expected 3 backfilled days, got %d", len(summaries))
+	}
+	for i, s := range summaries {
+		if s.Rounds != 1 || s.Avg == nil || *s.Avg != 20 {
+			t.Errorf("Day %d: unexpected summary %+v", i, s)
+		}
+	}
+}
@@ -4,141 +4,302 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"regexp"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
 )
 
 type PingStats struct {
-	Timestamp  time.Time  `json:"timestamp"`
-	Min        *float64   `json:"min"`                 // Nullable - NULL when no data available
-	Avg        *float64   `json:"avg"`                 // Nullable - NULL when no data available
-	Max        *float64   `json:"max"`                 // Nullable - NULL when no data available
-	StdDev     *float64   `json:"stddev"`              // Nullable - NULL when no data available
-	PacketLoss float64    `json:"packet_loss"`         // Percentage 0-100
+	Timestamp  time.Time    `json:"timestamp"`
+	Target     string       `json:"target"`      // Target name the round was sent to
+	Family     string       `json:"family"`      // "4", "6" or "" when the system picked
+	Min        *float64     `json:"min"`         // Nullable - NULL when no data available
+	Avg        *float64     `json:"avg"`         // Nullable - NULL when no data available
+	Max        *float64     `json:"max"`         // Nullable - NULL when no data available
+	StdDev     *float64     `json:"stddev"`      // Nullable - NULL when no data available
+	PacketLoss float64      `json:"packet_loss"` // Percentage 0-100
+	Probe      ProbeOptions `json:"probe"`       // Packet settings the round was sent with
+
+	AnomalyScore *float64 `json:"anomaly_score"` // Deviations of Avg from the series' baseline, nil without one
 }
 
-func initDB(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite", dbPath)
-	if err != nil {
-		return nil, err
+// DB wraps the SQLite connection pool with the prepared statements used on
+// the monitor's and dashboard's hot paths, so they are parsed once rather
+// than on every round and poll.
+type DB struct {
+	*sql.DB
+
+	insertStats      *sql.Stmt
+	recentStats      *sql.Stmt
+	statsByDateRange *sql.Stmt
+	statsSince       *sql.Stmt
+
+	addToDailySummary *sql.Stmt
+}
+
+// DBOptions trade durability against SD card writes.
+type DBOptions struct {
+	JournalMode string // SQLite journal_mode, e.g. "wal" or "delete"
+	Synchronous string // SQLite synchronous level: "off", "normal" or "full"
+}
+
+var defaultDBOptions = DBOptions{
+	JournalMode: "wal",
+	Synchronous: "normal",
+}
+
+func (o DBOptions) validate() error {
+	switch strings.ToLower(o.JournalMode) {
+	case "wal", "delete", "truncate", "persist", "memory":
+	default:
+		return fmt.Errorf("invalid journal_mode %q", o.JournalMode)
+	}
+	switch strings.ToLower(o.Synchronous) {
+	case "off", "normal", "full", "extra":
+	default:
+		return fmt.Errorf("invalid synchronous %q", o.Synchronous)
 	}
+	return nil
+}
+
+// dsn builds the connection string. Pragmas are passed through the DSN so
+// that every connection in the pool gets them, not just the first one.
+func (o DBOptions) dsn(dbPath string) string {
+	return dbPath + "?_pragma=busy_timeout(5000)" +
+		"&_pragma=journal_mode(" + o.JournalMode + ")" +
+		"&_pragma=synchronous(" + o.Synchronous + ")"
+}
+
+// memoryDBPath opens a database that lives only as long as the process.
+const memoryDBPath = ":memory:"
 
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS ping_stats (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		timestamp DATETIME NOT NULL,
-		min REAL,
-		avg REAL,
-		max REAL,
-		stddev REAL,
-		packet_loss REAL DEFAULT 0
-	);
-	`
+func initDB(dbPath string) (*DB, error) {
+	return openDB(dbPath, defaultDBOptions)
+}
+
+func openDB(dbPath string, opts DBOptions) (*DB, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
 
-	_, err = db.Exec(createTableSQL)
+	sqlDB, err := sql.Open("sqlite", opts.dsn(dbPath))
 	if err != nil {
 		return nil, err
 	}
+	if dbPath == memoryDBPath {
+		// Every connection to :memory: gets its own empty database
+		sqlDB.SetMaxOpenConns(1)
+	}
 
-	// Add packet_loss column to existing tables if it doesn't exist
-	alterTableSQL := `ALTER TABLE ping_stats ADD COLUMN packet_loss REAL DEFAULT 0`
-	_, _ = db.Exec(alterTableSQL) // Ignore error if column already exists
-
-	// Migrate existing tables: SQLite doesn't support ALTER COLUMN to drop NOT NULL
-	// We need to recreate the table if it has NOT NULL constraints
-	// Check if we need to migrate by looking at the table schema
-	var sql string
-	err = db.QueryRow("SELECT sql FROM sqlite_master WHERE type='table' AND name='ping_stats'").Scan(&sql)
-	if err == nil {
-		// Check if the old schema has "NOT NULL" for min column
-		if regexp.MustCompile(`min\s+REAL\s+NOT NULL`).MatchString(sql) {
-			log.Printf("Migrating database schema to allow NULL values for ping failures...")
-
-			// Create new table with correct schema
-			_, err = db.Exec(`
-				CREATE TABLE ping_stats_new (
-					id INTEGER PRIMARY KEY AUTOINCREMENT,
-					timestamp DATETIME NOT NULL,
-					min REAL,
-					avg REAL,
-					max REAL,
-					stddev REAL,
-					packet_loss REAL DEFAULT 0
-				)
-			`)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create new table: %v", err)
-			}
-
-			// Copy data from old table
-			_, err = db.Exec(`
-				INSERT INTO ping_stats_new (id, timestamp, min, avg, max, stddev, packet_loss)
-				SELECT id, timestamp, min, avg, max, stddev, COALESCE(packet_loss, 0)
-				FROM ping_stats
-			`)
-			if err != nil {
-				return nil, fmt.Errorf("failed to copy data: %v", err)
-			}
-
-			// Drop old table
-			_, err = db.Exec(`DROP TABLE ping_stats`)
-			if err != nil {
-				return nil, fmt.Errorf("failed to drop old table: %v", err)
-			}
-
-			// Rename new table
-			_, err = db.Exec(`ALTER TABLE ping_stats_new RENAME TO ping_stats`)
-			if err != nil {
-				return nil, fmt.Errorf("failed to rename table: %v", err)
-			}
-
-			log.Printf("Database schema migration completed successfully")
-		}
+	if err := migrateDB(sqlDB); err != nil {
+		sqlDB.Close()
+		return nil, err
 	}
 
-	// Create index on timestamp for better query performance
-	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_ping_stats_timestamp ON ping_stats(timestamp)`)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create timestamp index: %v", err)
+	db := &DB{DB: sqlDB}
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&db.insertStats, insertStatsSQL},
+		{&db.recentStats, recentStatsSQL},
+		{&db.statsByDateRange, statsByDateRangeSQL},
+		{&db.statsSince, statsSinceSQL},
+		{&db.addToDailySummary, addToDailySummarySQL},
+	}
+	for _, s := range statements {
+		if *s.stmt, err = sqlDB.Prepare(s.query); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to prepare statement: %v", err)
+		}
 	}
 
 	return db, nil
 }
 
-func savePingStats(db *sql.DB, stats *PingStats, retentionDays int) error {
-	insertSQL := `INSERT INTO ping_stats (timestamp, min, avg, max, stddev, packet_loss) VALUES (?, ?, ?, ?, ?, ?)`
-	_, err := db.Exec(insertSQL, stats.Timestamp, stats.Min, stats.Avg, stats.Max, stats.StdDev, stats.PacketLoss)
+// Close closes the prepared statements and the connection pool.
+func (db *DB) Close() error {
+	for _, stmt := range []*sql.Stmt{db.insertStats, db.recentStats, db.statsByDateRange, db.statsSince, db.addToDailySummary} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+	return db.DB.Close()
+}
+
+// PMTUResult is one path MTU discovery run. Changed is set when the MTU
+// differs from the previous run for the same target and family.
+type PMTUResult struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Target      string    `json:"target"`
+	Family      string    `json:"family"`
+	MTU         int       `json:"mtu"`
+	PreviousMTU int       `json:"previous_mtu"` // 0 for the first run
+	Changed     bool      `json:"changed"`
+}
+
+// Timestamps are stored as UTC epoch milliseconds. Integers compare and sort
+// by instant, whatever the server's timezone or DST offset when they were
+// written.
+func toEpochMillis(t time.Time) int64 {
+	return t.UnixMilli()
+}
+
+func fromEpochMillis(ms int64) time.Time {
+	return time.UnixMilli(ms).UTC()
+}
+
+// timestampLayouts are accepted for timestamps passed to the API. Prefer RFC
+// 3339 with an offset; the zoneless layout accepted by earlier versions is
+// read as UTC.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	// An unencoded "+" in a query string, e.g. ?since=...T14:00:00+02:00,
+	// arrives as a space
+	if n := len(s); n > 6 && s[n-6] == ' ' {
+		s = s[:n-6] + "+" + s[n-5:]
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not an RFC 3339 timestamp", s)
+}
+
+// adoptLegacyStats assigns rows recorded before targets were tracked to the
+// given target, which is the single host older versions were configured with.
+func adoptLegacyStats(db *DB, target string) error {
+	result, err := db.Exec(`UPDATE ping_stats SET target = ? WHERE target = ''`, target)
 	if err != nil {
 		return err
 	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Assigned %d existing rows to target %s", n, target)
+	}
+	return nil
+}
 
-	// Delete records older than retention period
-	cutoffTime := time.Now().AddDate(0, 0, -retentionDays)
-	deleteSQL := `DELETE FROM ping_stats WHERE timestamp < ?`
-	_, err = db.Exec(deleteSQL, cutoffTime)
-	return err
+const insertStatsSQL = `INSERT INTO ping_stats (timestamp, min, avg, max, stddev, packet_loss, target, family,
+	payload_size, dont_fragment, ttl, tos, source, anomaly_score) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+func savePingStats(store Store, stats *PingStats) error {
+	return store.SaveStats(stats)
 }
 
-func getRecentStats(db *sql.DB, limit int) ([]PingStats, error) {
-	query := `SELECT timestamp, min, avg, max, stddev, COALESCE(packet_loss, 0) FROM ping_stats ORDER BY timestamp DESC LIMIT ?`
-	rows, err := db.Query(query, limit)
+// SaveStats inserts rounds with the prepared statement in a single
+// transaction, adding each to its day's summary.
+func (db *DB) SaveStats(stats ...*PingStats) error {
+	tx, err := db.Begin()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	stmt := tx.Stmt(db.insertStats)
+	summaryStmt := tx.Stmt(db.addToDailySummary)
+	for _, s := range stats {
+		if err := insertPingStats(stmt, s); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := addToDailySummary(summaryStmt, s); err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
+	return tx.Commit()
+}
+
+// insertPingStats runs the prepared insert, either directly or bound to a
+// transaction with tx.Stmt.
+func insertPingStats(stmt *sql.Stmt, stats *PingStats) error {
+	_, err := stmt.Exec(toEpochMillis(stats.Timestamp), stats.Min, stats.Avg, stats.Max, stats.StdDev, stats.PacketLoss,
+		stats.Target, stats.Family, stats.Probe.Size, stats.Probe.DontFragment, stats.Probe.TTL, stats.Probe.TOS,
+		stats.Probe.Source, stats.AnomalyScore)
+	return err
+}
+
+// seriesFilterSQL matches rows for a target and family; an empty target or
+// family matches every value. It expects its arguments twice each, as
+// produced by seriesFilterArgs.
+const seriesFilterSQL = `(? = '' OR target = ?) AND (? = '' OR family = ?)`
+
+func seriesFilterArgs(target, family string) []any {
+	return []any{target, target, family, family}
+}
+
+const selectStatsSQL = `SELECT timestamp, min, avg, max, stddev, COALESCE(packet_loss, 0), target, family,
+	payload_size, dont_fragment, ttl, tos, source, anomaly_score FROM ping_stats`
+
+const recentStatsSQL = selectStatsSQL + `
+	WHERE ` + seriesFilterSQL + `
+	ORDER BY timestamp DESC LIMIT ?`
+
+const statsByDateRangeSQL = selectStatsSQL + `
+	WHERE ` + seriesFilterSQL + ` AND timestamp >= ? AND timestamp <= ?
+	ORDER BY timestamp ASC`
+
+const statsSinceSQL = selectStatsSQL + `
+	WHERE ` + seriesFilterSQL + ` AND timestamp > ?
+	ORDER BY timestamp ASC`
+
+// scanPingStats reads every row of a ping_stats query.
+func scanPingStats(rows *sql.Rows) ([]PingStats, error) {
 	defer rows.Close()
 
 	var stats []PingStats
 	for rows.Next() {
 		var s PingStats
+		var timestamp int64
 		// Scan into pointers - NULL values will result in nil pointers
-		err := rows.Scan(&s.Timestamp, &s.Min, &s.Avg, &s.Max, &s.StdDev, &s.PacketLoss)
+		err := rows.Scan(&timestamp, &s.Min, &s.Avg, &s.Max, &s.StdDev, &s.PacketLoss, &s.Target, &s.Family,
+			&s.Probe.Size, &s.Probe.DontFragment, &s.Probe.TTL, &s.Probe.TOS, &s.Probe.Source, &s.AnomalyScore)
 		if err != nil {
 			return nil, err
 		}
+		s.Timestamp = fromEpochMillis(timestamp)
 		stats = append(stats, s)
 	}
+	return stats, rows.Err()
+}
+
+func getRecentStats(store Store, target, family string, limit int) ([]PingStats, error) {
+	return store.RecentStats(target, family, limit)
+}
+
+func getStatsByDateRange(store Store, target, family, startDate, endDate string) ([]PingStats, error) {
+	startTime, err := parseTimestamp(startDate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start date format: %v", err)
+	}
+	endTime, err := parseTimestamp(endDate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end date format: %v", err)
+	}
+	return store.StatsBetween(target, family, startTime, endTime)
+}
+
+func getStatsSince(store Store, target, family, since string) ([]PingStats, error) {
+	sinceTime, err := parseTimestamp(since)
+	if err != nil {
+		return nil, fmt.Errorf("invalid since timestamp format: %v", err)
+	}
+	return store.StatsSince(target, family, sinceTime)
+}
+
+func (db *DB) RecentStats(target, family string, limit int) ([]PingStats, error) {
+	rows, err := db.recentStats.Query(append(seriesFilterArgs(target, family), limit)...)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := scanPingStats(rows)
+	if err != nil {
+		return nil, err
+	}
 
 	// Reverse to get chronological order
 	for i := 0; i < len(stats)/2; i++ {
@@ -149,66 +310,126 @@ func getRecentStats(db *sql.DB, limit int) ([]PingStats, error) {
 	return stats, nil
 }
 
-func getStatsByDateRange(db *sql.DB, startDate, endDate string) ([]PingStats, error) {
-	// Parse the input dates and convert to the format SQLite uses
-	startTime, err := time.Parse("2006-01-02T15:04:05", startDate)
+func (db *DB) StatsBetween(target, family string, start, end time.Time) ([]PingStats, error) {
+	rows, err := db.statsByDateRange.Query(append(seriesFilterArgs(target, family),
+		toEpochMillis(start), toEpochMillis(end))...)
 	if err != nil {
-		return nil, fmt.Errorf("invalid start date format: %v", err)
+		return nil, err
 	}
-	endTime, err := time.Parse("2006-01-02T15:04:05", endDate)
+	return scanPingStats(rows)
+}
+
+func (db *DB) StatsSince(target, family string, since time.Time) ([]PingStats, error) {
+	rows, err := db.statsSince.Query(append(seriesFilterArgs(target, family), toEpochMillis(since))...)
 	if err != nil {
-		return nil, fmt.Errorf("invalid end date format: %v", err)
+		return nil, err
 	}
+	return scanPingStats(rows)
+}
+
+// PruneStats deletes everything in the database older than cutoff, including
+// PMTU and bufferbloat results.
+func (db *DB) PruneStats(cutoff time.Time) (int64, error) {
+	return pruneOldStats(db, cutoff, pruneBatchSize)
+}
 
-	query := `SELECT timestamp, min, avg, max, stddev, COALESCE(packet_loss, 0) FROM ping_stats
-	          WHERE timestamp >= ? AND timestamp <= ?
-	          ORDER BY timestamp ASC`
-	rows, err := db.Query(query, startTime, endTime)
+// recordPMTU saves a discovered path MTU, flagging it as a change when it
+// differs from the last stored value for the same target and family.
+func recordPMTU(db *DB, target, family string, mtu int, timestamp time.Time) (*PMTUResult, error) {
+	result := &PMTUResult{
+		Timestamp: timestamp,
+		Target:    target,
+		Family:    family,
+		MTU:       mtu,
+	}
+
+	err := db.QueryRow(`SELECT mtu FROM pmtu_results WHERE target = ? AND family = ?
+	                    ORDER BY timestamp DESC LIMIT 1`, target, family).Scan(&result.PreviousMTU)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	result.Changed = result.PreviousMTU != 0 && result.PreviousMTU != mtu
+
+	_, err = db.Exec(`INSERT INTO pmtu_results (timestamp, target, family, mtu, previous_mtu, changed) VALUES (?, ?, ?, ?, ?, ?)`,
+		toEpochMillis(result.Timestamp), result.Target, result.Family, result.MTU, result.PreviousMTU, result.Changed)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// getPMTUHistory returns the most recent path MTU results in chronological
+// order. With changesOnly set, only runs where the MTU changed are returned.
+func getPMTUHistory(db *DB, target, family string, changesOnly bool, limit int) ([]PMTUResult, error) {
+	query := `SELECT timestamp, target, family, mtu, previous_mtu, changed FROM pmtu_results
+	          WHERE ` + seriesFilterSQL + ` AND (? = 0 OR changed = 1)
+	          ORDER BY timestamp DESC LIMIT ?`
+	rows, err := db.Query(query, append(seriesFilterArgs(target, family), changesOnly, limit)...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var stats []PingStats
+	var results []PMTUResult
 	for rows.Next() {
-		var s PingStats
-		// Scan into pointers - NULL values will result in nil pointers
-		err := rows.Scan(&s.Timestamp, &s.Min, &s.Avg, &s.Max, &s.StdDev, &s.PacketLoss)
+		var r PMTUResult
+		var timestamp int64
+		err := rows.Scan(&timestamp, &r.Target, &r.Family, &r.MTU, &r.PreviousMTU, &r.Changed)
 		if err != nil {
 			return nil, err
 		}
-		stats = append(stats, s)
+		r.Timestamp = fromEpochMillis(timestamp)
+		results = append(results, r)
 	}
 
-	return stats, nil
+	// Reverse to get chronological order
+	for i := 0; i < len(results)/2; i++ {
+		j := len(results) - 1 - i
+		results[i], results[j] = results[j], results[i]
+	}
+
+	return results, nil
 }
 
-func getStatsSince(db *sql.DB, since string) ([]PingStats, error) {
-	// Parse the timestamp
-	sinceTime, err := time.Parse(time.RFC3339, since)
-	if err != nil {
-		return nil, fmt.Errorf("invalid since timestamp format: %v", err)
-	}
+func saveBufferbloatResult(db *DB, r *BufferbloatResult) error {
+	insertSQL := `INSERT INTO bufferbloat_results (timestamp, target, family, idle_latency, download_latency, upload_latency,
+	              download_delta, upload_delta, download_mbps, upload_mbps, grade) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := db.Exec(insertSQL, toEpochMillis(r.Timestamp), r.Target, r.Family, r.IdleLatency, r.DownloadLatency, r.UploadLatency,
+		r.DownloadDelta, r.UploadDelta, r.DownloadMbps, r.UploadMbps, r.Grade)
+	return err
+}
 
-	query := `SELECT timestamp, min, avg, max, stddev, COALESCE(packet_loss, 0) FROM ping_stats
-	          WHERE timestamp > ?
-	          ORDER BY timestamp ASC`
-	rows, err := db.Query(query, sinceTime)
+// getBufferbloatHistory returns the most recent bufferbloat results in
+// chronological order.
+func getBufferbloatHistory(db *DB, target string, limit int) ([]BufferbloatResult, error) {
+	query := `SELECT timestamp, target, family, idle_latency, download_latency, upload_latency,
+	          download_delta, upload_delta, download_mbps, upload_mbps, grade FROM bufferbloat_results
+	          WHERE ` + seriesFilterSQL + `
+	          ORDER BY timestamp DESC LIMIT ?`
+	rows, err := db.Query(query, append(seriesFilterArgs(target, ""), limit)...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var stats []PingStats
+	var results []BufferbloatResult
 	for rows.Next() {
-		var s PingStats
-		// Scan into pointers - NULL values will result in nil pointers
-		err := rows.Scan(&s.Timestamp, &s.Min, &s.Avg, &s.Max, &s.StdDev, &s.PacketLoss)
+		var r BufferbloatResult
+		var timestamp int64
+		err := rows.Scan(&timestamp, &r.Target, &r.Family, &r.IdleLatency, &r.DownloadLatency, &r.UploadLatency,
+			&r.DownloadDelta, &r.UploadDelta, &r.DownloadMbps, &r.UploadMbps, &r.Grade)
 		if err != nil {
 			return nil, err
 		}
-		stats = append(stats, s)
+		r.Timestamp = fromEpochMillis(timestamp)
+		results = append(results, r)
 	}
 
-	return stats, nil
+	// Reverse to get chronological order
+	for i := 0; i < len(results)/2; i++ {
+		j := len(results) - 1 - i
+		results[i], results[j] = results[j], results[i]
+	}
+
+	return results, nil
 }
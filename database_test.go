@@ -50,7 +50,7 @@ func TestSavePingStats(t *testing.T) {
 		StdDev:    float64Ptr(2.1),
 	}
 
-	err = savePingStats(db, stats, 30)
+	err = savePingStats(db, stats)
 	if err != nil {
 		t.Fatalf("Failed to save ping stats: %v", err)
 	}
@@ -86,14 +86,14 @@ func TestGetRecentStats(t *testing.T) {
 			Max:       float64Ptr(15.0 + float64(i)),
 			StdDev:    float64Ptr(2.0),
 		}
-		err = savePingStats(db, stats, 30)
+		err = savePingStats(db, stats)
 		if err != nil {
 			t.Fatalf("Failed to save test data: %v", err)
 		}
 	}
 
 	// Get recent stats
-	stats, err := getRecentStats(db, 3)
+	stats, err := getRecentStats(db, "", "", 3)
 	if err != nil {
 		t.Fatalf("Failed to get recent stats: %v", err)
 	}
@@ -128,7 +128,7 @@ func TestGetStatsByDateRange(t *testing.T) {
 			Max:       float64Ptr(15.0 + float64(i)),
 			StdDev:    float64Ptr(2.0),
 		}
-		err = savePingStats(db, stats, 30)
+		err = savePingStats(db, stats)
 		if err != nil {
 			t.Fatalf("Failed to save test data: %v", err)
 		}
@@ -138,7 +138,7 @@ func TestGetStatsByDateRange(t *testing.T) {
 	startDate := baseTime.Add(1 * time.Hour).Format("2006-01-02T15:04:05")
 	endDate := baseTime.Add(3 * time.Hour).Format("2006-01-02T15:04:05")
 
-	stats, err := getStatsByDateRange(db, startDate, endDate)
+	stats, err := getStatsByDateRange(db, "", "", startDate, endDate)
 	if err != nil {
 		t.Fatalf("Failed to get stats by date range: %v", err)
 	}
@@ -172,7 +172,7 @@ func TestRetentionPolicy(t *testing.T) {
 		Max:       float64Ptr(15.0),
 		StdDev:    float64Ptr(2.0),
 	}
-	err = savePingStats(db, oldStats, 30)
+	err = savePingStats(db, oldStats)
 	if err != nil {
 		t.Fatalf("Failed to save old data: %v", err)
 	}
@@ -185,13 +185,22 @@ func TestRetentionPolicy(t *testing.T) {
 		Max:       float64Ptr(16.0),
 		StdDev:    float64Ptr(2.5),
 	}
-	err = savePingStats(db, recentStats, 30)
+	err = savePingStats(db, recentStats)
 	if err != nil {
 		t.Fatalf("Failed to save recent data: %v", err)
 	}
 
+	// Run the maintenance prune with a 30 day retention
+	removed, err := pruneOldStats(db, time.Now().AddDate(0, 0, -30), pruneBatchSize)
+	if err != nil {
+		t.Fatalf("Failed to prune old data: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 row removed, got %d", removed)
+	}
+
 	// Verify only recent data remains
-	stats, err := getRecentStats(db, 10)
+	stats, err := getRecentStats(db, "", "", 10)
 	if err != nil {
 		t.Fatalf("Failed to get stats: %v", err)
 	}
@@ -215,13 +224,278 @@ func TestGetStatsByDateRangeInvalidFormat(t *testing.T) {
 	}
 	defer db.Close()
 
-	_, err = getStatsByDateRange(db, "invalid-date", "2025-10-19T15:00:00")
+	_, err = getStatsByDateRange(db, "", "", "invalid-date", "2025-10-19T15:00:00")
 	if err == nil {
 		t.Error("Expected error for invalid start date format, got nil")
 	}
 
-	_, err = getStatsByDateRange(db, "2025-10-19T15:00:00", "invalid-date")
+	_, err = getStatsByDateRange(db, "", "", "2025-10-19T15:00:00", "invalid-date")
 	if err == nil {
 		t.Error("Expected error for invalid end date format, got nil")
 	}
 }
+
+func TestGetRecentStatsBySeries(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	db, err := initDB(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	// One round per family, as recorded for a target with family = "both"
+	baseTime := time.Now()
+	for i, family := range []string{FamilyIPv4, FamilyIPv6} {
+		stats := &PingStats{
+			Timestamp: baseTime.Add(time.Duration(i) * time.Second),
+			Target:    "google",
+			Family:    family,
+			Avg:       float64Ptr(10.0 + float64(i)),
+		}
+		if err := savePingStats(db, stats); err != nil {
+			t.Fatalf("Failed to save test data: %v", err)
+		}
+	}
+
+	stats, err := getRecentStats(db, "google", FamilyIPv6, 10)
+	if err != nil {
+		t.Fatalf("Failed to get recent stats: %v", err)
+	}
+	if len(stats) != 1 {
+		t.Fatalf("Expected 1 IPv6 stat, got %d", len(stats))
+	}
+	if stats[0].Family != FamilyIPv6 || stats[0].Target != "google" {
+		t.Errorf("Expected google/6, got %s/%s", stats[0].Target, stats[0].Family)
+	}
+
+	stats, err = getRecentStats(db, "google", "", 10)
+	if err != nil {
+		t.Fatalf("Failed to get recent stats: %v", err)
+	}
+	if len(stats) != 2 {
+		t.Errorf("Expected 2 stats across families, got %d", len(stats))
+	}
+}
+
+func TestAdoptLegacyStats(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	db, err := initDB(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	if err := savePingStats(db, &PingStats{Timestamp: time.Now()}); err != nil {
+		t.Fatalf("Failed to save test data: %v", err)
+	}
+
+	if err := adoptLegacyStats(db, "8.8.8.8"); err != nil {
+		t.Fatalf("Failed to adopt legacy stats: %v", err)
+	}
+
+	stats, err := getRecentStats(db, "8.8.8.8", "", 10)
+	if err != nil {
+		t.Fatalf("Failed to get recent stats: %v", err)
+	}
+	if len(stats) != 1 {
+		t.Errorf("Expected legacy row to be assigned to 8.8.8.8, got %d rows", len(stats))
+	}
+}
+
+func TestSavePingStatsProbeOptions(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	db, err := initDB(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	probe := ProbeOptions{Size: 1472, DontFragment: true, TTL: 64, TOS: 184, Source: "eth0"}
+	if err := savePingStats(db, &PingStats{Timestamp: time.Now(), Target: "isp", Probe: probe}); err != nil {
+		t.Fatalf("Failed to save ping stats: %v", err)
+	}
+
+	stats, err := getRecentStats(db, "isp", "", 1)
+	if err != nil {
+		t.Fatalf("Failed to get recent stats: %v", err)
+	}
+	if len(stats) != 1 {
+		t.Fatalf("Expected 1 stat, got %d", len(stats))
+	}
+	if stats[0].Probe != probe {
+		t.Errorf("Expected probe options %+v, got %+v", probe, stats[0].Probe)
+	}
+}
+
+func TestRecordPMTU(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	db, err := initDB(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	baseTime := time.Now()
+	for i, mtu := range []int{1500, 1500, 1492, 1492} {
+		result, err := recordPMTU(db, "isp", FamilyIPv4, mtu, baseTime.Add(time.Duration(i)*time.Hour))
+		if err != nil {
+			t.Fatalf("Failed to record path MTU: %v", err)
+		}
+		if wantChanged := i == 2; result.Changed != wantChanged {
+			t.Errorf("Run %d: expected changed=%v, got %v", i, wantChanged, result.Changed)
+		}
+	}
+
+	history, err := getPMTUHistory(db, "isp", "", false, 10)
+	if err != nil {
+		t.Fatalf("Failed to get path MTU history: %v", err)
+	}
+	if len(history) != 4 {
+		t.Fatalf("Expected 4 results, got %d", len(history))
+	}
+	if history[0].MTU != 1500 || history[3].MTU != 1492 {
+		t.Errorf("Expected chronological order 1500..1492, got %d..%d", history[0].MTU, history[3].MTU)
+	}
+
+	changes, err := getPMTUHistory(db, "isp", FamilyIPv4, true, 10)
+	if err != nil {
+		t.Fatalf("Failed to get path MTU changes: %v", err)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("Expected 1 change event, got %d", len(changes))
+	}
+	if changes[0].PreviousMTU != 1500 || changes[0].MTU != 1492 {
+		t.Errorf("Expected change 1500 -> 1492, got %d -> %d", changes[0].PreviousMTU, changes[0].MTU)
+	}
+}
+
+func TestOpenDBPragmas(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	db, err := initDB(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	var journalMode string
+	if err := db.QueryRow(`PRAGMA journal_mode`).Scan(&journalMode); err != nil {
+		t.Fatalf("Failed to read journal_mode: %v", err)
+	}
+	if journalMode != "wal" {
+		t.Errorf("Expected WAL journal mode, got %s", journalMode)
+	}
+
+	var busyTimeout int
+	if err := db.QueryRow(`PRAGMA busy_timeout`).Scan(&busyTimeout); err != nil {
+		t.Fatalf("Failed to read busy_timeout: %v", err)
+	}
+	if busyTimeout != 5000 {
+		t.Errorf("Expected busy timeout 5000, got %d", busyTimeout)
+	}
+}
+
+func TestOpenDBInvalidOptions(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	_, err := openDB(dbPath, DBOptions{JournalMode: "wal", Synchronous: "sometimes"})
+	if err == nil {
+		t.Error("Expected error for invalid synchronous level, got nil")
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	want := time.Date(2025, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	tests := []string{
+		"2025-10-19T12:00:00Z",
+		"2025-10-19T14:00:00+02:00",
+		"2025-10-19T14:00:00 02:00", // The same with its "+" decoded from a query string
+		"2025-10-19T08:00:00.000-04:00",
+		"2025-10-19T12:00:00", // Zoneless, read as UTC
+	}
+	for _, input := range tests {
+		got, err := parseTimestamp(input)
+		if err != nil {
+			t.Errorf("parseTimestamp(%q) error: %v", input, err)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("parseTimestamp(%q) = %v, want %v", input, got, want)
+		}
+	}
+
+	if _, err := parseTimestamp("19/10/2025 12:00"); err == nil {
+		t.Error("Expected error for a non-RFC 3339 timestamp, got nil")
+	}
+}
+
+func TestStatsAcrossDSTBoundary(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("Time zone data not available: %v", err)
+	}
+
+	db, err := initDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	// Clocks fall back from 02:00 EDT to 01:00 EST on 2025-11-02, so local
+	// times between 01:00 and 02:00 happen twice. Record a round every 10
+	// minutes from 00:30 EDT to 02:30 EST in local time.
+	start := time.Date(2025, 11, 2, 0, 30, 0, 0, newYork)
+	for i := 0; i <= 18; i++ {
+		stats := &PingStats{
+			Timestamp: start.Add(time.Duration(i) * 10 * time.Minute),
+			Avg:       float64Ptr(float64(i)),
+		}
+		if err := savePingStats(db, stats); err != nil {
+			t.Fatalf("Failed to save test data: %v", err)
+		}
+	}
+
+	all, err := getRecentStats(db, "", "", 100)
+	if err != nil {
+		t.Fatalf("Failed to get recent stats: %v", err)
+	}
+	if len(all) != 19 {
+		t.Fatalf("Expected 19 rounds, got %d", len(all))
+	}
+	for i, s := range all {
+		if *s.Avg != float64(i) {
+			t.Fatalf("Expected rounds in recording order, got round %v at position %d", *s.Avg, i)
+		}
+		if s.Timestamp.Location() != time.UTC {
+			t.Errorf("Expected UTC timestamps, got %v", s.Timestamp)
+		}
+	}
+
+	// The repeated hour, 01:00 EDT to 01:00 EST, is an hour of real time
+	stats, err := getStatsByDateRange(db, "", "", "2025-11-02T01:00:00-04:00", "2025-11-02T01:00:00-05:00")
+	if err != nil {
+		t.Fatalf("Failed to get stats by date range: %v", err)
+	}
+	if len(stats) != 7 || *stats[0].Avg != 3 || *stats[6].Avg != 9 {
+		t.Errorf("Expected rounds 3 to 9 in the repeated hour, got %d rounds", len(stats))
+	}
+
+	// Polling from a browser in another timezone sees the same rounds
+	since, err := getStatsSince(db, "", "", "2025-11-02T07:30:00+01:00")
+	if err != nil {
+		t.Fatalf("Failed to get stats since: %v", err)
+	}
+	if len(since) != 6 || *since[0].Avg != 13 {
+		t.Errorf("Expected the last 6 rounds, got %d rounds", len(since))
+	}
+}
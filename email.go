@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTP connection security settings.
+const (
+	SMTPStartTLS = "starttls" // Upgrade a plain connection, usually on port 587
+	SMTPTLS      = "tls"      // Implicit TLS, usually on port 465
+	SMTPNone     = "none"     // No encryption, e.g. a relay on localhost
+)
+
+// smtpTimeout bounds connecting to and talking with the mail server.
+const smtpTimeout = 30 * time.Second
+
+// emailNotifier sends alerts as multipart plain-text and HTML email.
+type emailNotifier struct {
+	host     string
+	port     int
+	security string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func newEmailNotifier(c NotifierConfig) *emailNotifier {
+	n := &emailNotifier{
+		host:     c.Host,
+		port:     c.Port,
+		security: c.TLS,
+		username: c.Username,
+		password: c.Password,
+		from:     c.From,
+		to:       c.To,
+	}
+	if n.security == "" {
+		n.security = SMTPStartTLS
+	}
+	if n.port == 0 {
+		switch n.security {
+		case SMTPTLS:
+			n.port = 465
+		case SMTPStartTLS:
+			n.port = 587
+		default:
+			n.port = 25
+		}
+	}
+	return n
+}
+
+func (n *emailNotifier) Notify(alert Alert) error {
+	message, err := alertEmail(alert, n.from, n.to, time.Now())
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(n.host, strconv.Itoa(n.port))
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	tlsConfig := &tls.Config{ServerName: n.host}
+
+	var conn net.Conn
+	if n.security == SMTPTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if n.security == SMTPStartTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("starttls: %v", err)
+		}
+	}
+	if n.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.username, n.password, n.host)); err != nil {
+			return fmt.Errorf("auth: %v", err)
+		}
+	}
+
+	if err := client.Mail(n.from); err != nil {
+		return err
+	}
+	for _, to := range n.to {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s: %v", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// alertEmail builds a multipart/alternative message with plain-text and
+// HTML versions of the alert.
+func alertEmail(alert Alert, from string, to []string, date time.Time) ([]byte, error) {
+	html, err := alertHTML(alert)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", alertText(alert)},
+		{"text/html; charset=utf-8", html},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", alertSubject(alert)))
+	fmt.Fprintf(&message, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", parts.Boundary())
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
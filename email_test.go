@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpStandIn accepts a single message over plain SMTP and returns the
+// envelope recipients and message data.
+func smtpStandIn(t *testing.T) (port int, received chan []string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received = make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+		reply("220 localhost ESMTP")
+
+		var recipients []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.Fields(line)[0])
+			switch command {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "MAIL":
+				reply("250 OK")
+			case "RCPT":
+				recipients = append(recipients, strings.TrimSpace(line))
+				reply("250 OK")
+			case "DATA":
+				reply("354 Go ahead")
+				var data strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				reply("250 OK")
+				received <- append(recipients, data.String())
+			case "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("502 Not implemented")
+			}
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, received
+}
+
+func TestEmailNotifier(t *testing.T) {
+	port, received := smtpStandIn(t)
+
+	notifier := newEmailNotifier(NotifierConfig{
+		Host: "127.0.0.1",
+		Port: port,
+		TLS:  SMTPNone,
+		From: "pingo@example.com",
+		To:   []string{"ops@example.com", "oncall@example.com"},
+	})
+	alert := Alert{
+		Rule:      "isp-down",
+		Status:    AlertFiring,
+		Series:    "isp/IPv4",
+		Condition: "packet loss above 50.0%",
+		Started:   time.Date(2025, 10, 19, 3, 0, 0, 0, time.UTC),
+		Summary:   RoundsSummary{Rounds: 3, PacketLoss: 80},
+	}
+	if err := notifier.Notify(alert); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	var got []string
+	select {
+	case got = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("No message received")
+	}
+	if len(got) != 3 || !strings.Contains(got[0], "<ops@example.com>") || !strings.Contains(got[1], "<oncall@example.com>") {
+		t.Fatalf("Expected both recipients, got %q", got[:len(got)-1])
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(got[2]))
+	if err != nil {
+		t.Fatalf("Invalid message: %v", err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if subject != "[FIRING] isp-down: isp/IPv4 packet loss above 50.0%" {
+		t.Errorf("Unexpected subject %q", subject)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Expected multipart/alternative, got %q", msg.Header.Get("Content-Type"))
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for _, want := range []struct{ contentType, content string }{
+		{"text/plain", "Packet loss: 80.0%"},
+		{"text/html", "<h2>FIRING: isp-down</h2>"},
+	} {
+		part, err := parts.NextPart()
+		if err != nil {
+			t.Fatalf("Missing %s part: %v", want.contentType, err)
+		}
+		body, _ := io.ReadAll(part)
+		if !strings.HasPrefix(part.Header.Get("Content-Type"), want.contentType) || !strings.Contains(string(body), want.content) {
+			t.Errorf("Expected %s part containing %q, got %q: %s", want.contentType, want.content, part.Header.Get("Content-Type"), body)
+		}
+	}
+}
+
+func TestEmailNotifierDefaultPorts(t *testing.T) {
+	for security, want := range map[string]int{"": 587, SMTPStartTLS: 587, SMTPTLS: 465, SMTPNone: 25} {
+		if got := newEmailNotifier(NotifierConfig{TLS: security}).port; got != want {
+			t.Errorf("tls=%q: expected port %d, got %d", security, want, got)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// roundPause is how long each target's monitor waits between ping rounds.
+const roundPause = 5 * time.Second
+
+// roundInterval estimates how often a series completes a round: the pings
+// themselves, one per second, plus the pause between rounds.
+func roundInterval(pingCount int) time.Duration {
+	return time.Duration(pingCount)*time.Second + roundPause
+}
+
+// monitorHealth records the ping monitor's progress so /readyz can tell a
+// stuck monitor from a healthy one.
+type monitorHealth struct {
+	mu            sync.Mutex
+	started       time.Time
+	lastRound     map[string]time.Time // Keyed by series label
+	lastError     string
+	lastErrorTime time.Time
+	commitError   error // Set while buffered rounds fail to commit
+}
+
+func newMonitorHealth(started time.Time) *monitorHealth {
+	return &monitorHealth{started: started, lastRound: make(map[string]time.Time)}
+}
+
+// roundCompleted marks a series' round as saved.
+func (h *monitorHealth) roundCompleted(label string, at time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastRound[label] = at
+}
+
+// recordError keeps the most recent monitor error for the readiness report.
+func (h *monitorHealth) recordError(label string, err error, at time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastError = fmt.Sprintf("[%s] %v", label, err)
+	h.lastErrorTime = at
+}
+
+// commitFinished records the outcome of committing buffered rounds. Rounds
+// count as completed once queued, so a failing commit is what shows that
+// they aren't reaching the database.
+func (h *monitorHealth) commitFinished(err error, at time.Time) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.commitError = err
+	if err != nil {
+		h.lastError = fmt.Sprintf("failed to commit buffered rounds: %v", err)
+		h.lastErrorTime = at
+	}
+}
+
+// SeriesHealth is one series' entry in the readiness report.
+type SeriesHealth struct {
+	Label     string     `json:"label"`
+	LastRound *time.Time `json:"last_round"` // nil until the first round is saved
+	Stale     bool       `json:"stale"`
+}
+
+// ReadinessReport is the body served by /readyz.
+type ReadinessReport struct {
+	Ready         bool           `json:"ready"`
+	Database      string         `json:"database"`   // "ok" or why the database can't be written
+	LastRound     *time.Time     `json:"last_round"` // Most recent round of any series
+	LastError     string         `json:"last_error,omitempty"`
+	LastErrorTime *time.Time     `json:"last_error_time,omitempty"`
+	Series        []SeriesHealth `json:"series"`
+}
+
+// report checks that every series completed a round within maxAge. Series
+// that haven't run yet are measured from when the monitor started, so a
+// fresh process gets the same grace period.
+func (h *monitorHealth) report(series []SeriesInfo, maxAge time.Duration, dbErr error, now time.Time) ReadinessReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if dbErr == nil && h.commitError != nil {
+		dbErr = fmt.Errorf("failed to commit buffered rounds: %v", h.commitError)
+	}
+	report := ReadinessReport{Ready: dbErr == nil, Database: "ok"}
+	if dbErr != nil {
+		report.Database = dbErr.Error()
+	}
+	if h.lastError != "" {
+		errorTime := h.lastErrorTime
+		report.LastError, report.LastErrorTime = h.lastError, &errorTime
+	}
+
+	for _, s := range series {
+		entry := SeriesHealth{Label: s.Label}
+		since := h.started
+		if last, ok := h.lastRound[s.Label]; ok {
+			entry.LastRound = &last
+			since = last
+			if report.LastRound == nil || last.After(*report.LastRound) {
+				report.LastRound = &last
+			}
+		}
+		if now.Sub(since) > maxAge {
+			entry.Stale = true
+			report.Ready = false
+		}
+		report.Series = append(report.Series, entry)
+	}
+	return report
+}
+
+// checkWritable confirms the database accepts writes by rewriting the
+// schema version inside a transaction that is then rolled back.
+func checkWritable(db *DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version)); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMonitorHealthReport(t *testing.T) {
+	started := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	series := listSeries([]TargetConfig{{Name: "google", Family: FamilyBoth}})
+	maxAge := 3 * roundInterval(5)
+
+	health := newMonitorHealth(started)
+
+	// A fresh monitor is ready until the grace period runs out
+	report := health.report(series, maxAge, nil, started.Add(time.Second))
+	if !report.Ready || report.LastRound != nil {
+		t.Errorf("Expected a fresh monitor to be ready with no rounds, got %+v", report)
+	}
+	report = health.report(series, maxAge, nil, started.Add(maxAge+time.Second))
+	if report.Ready {
+		t.Error("Expected not ready when no round completed within the grace period")
+	}
+
+	// One family keeps saving rounds, the other is stuck
+	health.roundCompleted("google/IPv4", started.Add(time.Minute))
+	health.recordError("google/IPv6", errors.New("ping: connect: Network is unreachable"), started.Add(time.Minute))
+	report = health.report(series, maxAge, nil, started.Add(time.Minute+time.Second))
+	if report.Ready {
+		t.Error("Expected not ready while google/IPv6 has no recent round")
+	}
+	if report.Series[0].Stale || !report.Series[1].Stale {
+		t.Errorf("Expected only google/IPv6 to be stale, got %+v", report.Series)
+	}
+	if report.LastRound == nil || !report.LastRound.Equal(started.Add(time.Minute)) {
+		t.Errorf("Expected last round at %v, got %v", started.Add(time.Minute), report.LastRound)
+	}
+	if report.LastError != "[google/IPv6] ping: connect: Network is unreachable" || report.LastErrorTime == nil {
+		t.Errorf("Unexpected last error %q at %v", report.LastError, report.LastErrorTime)
+	}
+
+	health.roundCompleted("google/IPv6", started.Add(time.Minute))
+	report = health.report(series, maxAge, nil, started.Add(time.Minute+time.Second))
+	if !report.Ready {
+		t.Errorf("Expected ready once every series saved a round, got %+v", report)
+	}
+
+	// A database that can't be written fails readiness on its own
+	report = health.report(series, maxAge, errors.New("attempt to write a readonly database"), started.Add(time.Minute+time.Second))
+	if report.Ready || report.Database != "attempt to write a readonly database" {
+		t.Errorf("Expected not ready with the database error reported, got %+v", report)
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	db, err := initDB(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	if err := checkWritable(db); err != nil {
+		t.Errorf("Expected database to be writable, got %v", err)
+	}
+
+	// The check must not change the schema version
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != schemaVersion() {
+		t.Errorf("Expected schema version %d, got %d", schemaVersion(), version)
+	}
+
+	readOnly, err := sql.Open("sqlite", "file:"+dbPath+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+	if err := checkWritable(&DB{DB: readOnly}); err == nil {
+		t.Error("Expected an error for a read-only database")
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeartbeatConfig configures a dead man's switch: an external service such
+// as healthchecks.io that raises the alarm when pingo stops checking in.
+type HeartbeatConfig struct {
+	URL        string        `toml:"url"`         // Requested after successful rounds
+	FailureURL string        `toml:"failure_url"` // Requested when every ping of a round is lost
+	Method     string        `toml:"method"`      // "GET" (default) or "POST" with a short round summary
+	Interval   time.Duration `toml:"interval"`    // At most one request per interval, 0 for every round
+	Target     string        `toml:"target"`      // Target name whose rounds count (default: first target)
+}
+
+func (c HeartbeatConfig) enabled() bool {
+	return c.URL != "" || c.FailureURL != ""
+}
+
+func (c HeartbeatConfig) validate() error {
+	for _, u := range []string{c.URL, c.FailureURL} {
+		if u == "" {
+			continue
+		}
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return fmt.Errorf("invalid url %q", u)
+		}
+	}
+	if c.Method != "" && c.Method != http.MethodGet && c.Method != http.MethodPost {
+		return fmt.Errorf("invalid method %q: must be GET or POST", c.Method)
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("interval must not be negative")
+	}
+	return nil
+}
+
+// heartbeatQueue bounds how many requests wait while the service is slow;
+// beyond that new ones are dropped, which a heartbeat can afford.
+const heartbeatQueue = 16
+
+type heartbeatPing struct {
+	url, body string
+}
+
+// heartbeat signals the outcome of the target's rounds to the external
+// service. Requests go out in order from a single goroutine so a slow
+// service never holds up the monitor.
+type heartbeat struct {
+	config HeartbeatConfig
+	target string
+	client *http.Client
+	pings  chan heartbeatPing
+
+	mu       sync.Mutex
+	families map[string]heartbeatResult // Latest round of each of the target's families
+	lastSent time.Time
+	lastOK   *bool // Combined outcome last signalled
+}
+
+type heartbeatResult struct {
+	ok   bool
+	body string
+}
+
+func newHeartbeat(config HeartbeatConfig, target string) *heartbeat {
+	if config.Method == "" {
+		config.Method = http.MethodGet
+	}
+	return &heartbeat{
+		config:   config,
+		target:   target,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		pings:    make(chan heartbeatPing, heartbeatQueue),
+		families: make(map[string]heartbeatResult),
+	}
+}
+
+// observe signals a round of one of the target's families. stats is nil
+// when the round produced no results at all. A dual-stack target counts as
+// up only while every family is, so one family failing doesn't make the
+// signal alternate. A change between success and failure is signalled at
+// once; otherwise at most once per interval.
+func (h *heartbeat) observe(target, family string, stats *PingStats, at time.Time) {
+	if h == nil || target != h.target {
+		return
+	}
+
+	h.mu.Lock()
+	h.families[family] = heartbeatResult{
+		ok:   stats != nil && stats.PacketLoss < 100,
+		body: heartbeatBody(target, family, stats),
+	}
+	ok, body := h.combined()
+	changed := h.lastOK == nil || *h.lastOK != ok
+	if !changed && h.config.Interval > 0 && at.Sub(h.lastSent) < h.config.Interval {
+		h.mu.Unlock()
+		return
+	}
+	h.lastOK, h.lastSent = &ok, at
+	h.mu.Unlock()
+
+	ping := heartbeatPing{url: h.config.URL, body: body}
+	if !ok {
+		ping.url = h.config.FailureURL
+	}
+	if ping.url == "" {
+		return
+	}
+	select {
+	case h.pings <- ping:
+	default:
+		log.Printf("Heartbeat: requests are backing up, dropping one")
+	}
+}
+
+// combined returns whether every family's latest round succeeded, and their
+// summaries. Callers hold h.mu.
+func (h *heartbeat) combined() (bool, string) {
+	families := make([]string, 0, len(h.families))
+	for family := range h.families {
+		families = append(families, family)
+	}
+	sort.Strings(families)
+
+	ok := true
+	bodies := make([]string, len(families))
+	for i, family := range families {
+		ok = ok && h.families[family].ok
+		bodies[i] = h.families[family].body
+	}
+	return ok, strings.Join(bodies, "\n")
+}
+
+func heartbeatBody(target, family string, stats *PingStats) string {
+	label := seriesLabel(target, family)
+	if stats == nil {
+		return label + ": round failed"
+	}
+	if stats.Avg == nil {
+		return fmt.Sprintf("%s: loss %.1f%%", label, stats.PacketLoss)
+	}
+	return fmt.Sprintf("%s: loss %.1f%%, avg %.3f ms", label, stats.PacketLoss, *stats.Avg)
+}
+
+// Run sends queued heartbeat requests.
+func (h *heartbeat) Run() {
+	log.Printf("Heartbeat: signalling rounds of %s", h.target)
+	for ping := range h.pings {
+		if err := h.send(ping); err != nil {
+			log.Printf("Heartbeat: %v", err)
+		}
+	}
+}
+
+func (h *heartbeat) send(ping heartbeatPing) error {
+	var body io.Reader
+	if h.config.Method == http.MethodPost {
+		body = strings.NewReader(ping.body)
+	}
+	// The URL is the check's credential, so errors leave it out
+	req, err := http.NewRequest(h.config.Method, ping.url, body)
+	if err != nil {
+		return redactURL(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "text/plain")
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return redactURL(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s request returned %s", h.config.Method, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type heartbeatRequest struct {
+	method, path, body string
+}
+
+func heartbeatStandIn(t *testing.T) (*httptest.Server, chan heartbeatRequest) {
+	t.Helper()
+
+	requests := make(chan heartbeatRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- heartbeatRequest{r.Method, r.URL.Path, string(body)}
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+// drain returns the requests the heartbeat has queued so far.
+func drain(h *heartbeat) []heartbeatPing {
+	var pings []heartbeatPing
+	for {
+		select {
+		case ping := <-h.pings:
+			pings = append(pings, ping)
+		default:
+			return pings
+		}
+	}
+}
+
+func TestHeartbeatEveryRound(t *testing.T) {
+	h := newHeartbeat(HeartbeatConfig{URL: "https://hc.example.com/uuid", FailureURL: "https://hc.example.com/uuid/fail"}, "isp")
+	now := time.Now()
+
+	h.observe("isp", FamilyIPv4, &PingStats{Family: FamilyIPv4, Avg: float64Ptr(12)}, now)
+	h.observe("isp", FamilyIPv4, &PingStats{Family: FamilyIPv4, Avg: float64Ptr(13)}, now.Add(10*time.Second))
+	h.observe("google", FamilyAny, &PingStats{PacketLoss: 100}, now.Add(15*time.Second))
+	h.observe("isp", FamilyIPv4, &PingStats{Family: FamilyIPv4, PacketLoss: 100}, now.Add(20*time.Second))
+	h.observe("isp", FamilyIPv4, nil, now.Add(30*time.Second))
+
+	pings := drain(h)
+	if len(pings) != 4 {
+		t.Fatalf("Expected a request for each isp round, got %+v", pings)
+	}
+	for i, want := range []string{"https://hc.example.com/uuid", "https://hc.example.com/uuid", "https://hc.example.com/uuid/fail", "https://hc.example.com/uuid/fail"} {
+		if pings[i].url != want {
+			t.Errorf("Request %d: expected %s, got %s", i, want, pings[i].url)
+		}
+	}
+	if pings[0].body != "isp/IPv4: loss 0.0%, avg 12.000 ms" || pings[3].body != "isp/IPv4: round failed" {
+		t.Errorf("Unexpected bodies %q and %q", pings[0].body, pings[3].body)
+	}
+}
+
+func TestHeartbeatInterval(t *testing.T) {
+	h := newHeartbeat(HeartbeatConfig{URL: "https://hc.example.com/uuid", Interval: time.Minute}, "isp")
+	now := time.Now()
+
+	h.observe("isp", FamilyAny, &PingStats{}, now)
+	h.observe("isp", FamilyAny, &PingStats{}, now.Add(30*time.Second))
+	if pings := drain(h); len(pings) != 1 {
+		t.Fatalf("Expected one request within the interval, got %+v", pings)
+	}
+
+	h.observe("isp", FamilyAny, &PingStats{}, now.Add(time.Minute))
+	if pings := drain(h); len(pings) != 1 {
+		t.Fatalf("Expected a request once the interval passed, got %+v", pings)
+	}
+
+	// Without a failure URL a failing round sends nothing, and the
+	// recovery is signalled straight away
+	h.observe("isp", FamilyAny, &PingStats{PacketLoss: 100}, now.Add(70*time.Second))
+	h.observe("isp", FamilyAny, &PingStats{}, now.Add(80*time.Second))
+	if pings := drain(h); len(pings) != 1 || pings[0].url != "https://hc.example.com/uuid" {
+		t.Fatalf("Expected only the recovery to be signalled, got %+v", pings)
+	}
+}
+
+func TestHeartbeatDualStack(t *testing.T) {
+	h := newHeartbeat(HeartbeatConfig{URL: "https://hc.example.com/uuid", FailureURL: "https://hc.example.com/uuid/fail", Interval: time.Minute}, "isp")
+	now := time.Now()
+
+	// IPv4 is up and IPv6 down: the target is failing, and stays so
+	// rather than alternating with every round
+	for i := 0; i < 4; i++ {
+		at := now.Add(time.Duration(i) * 15 * time.Second)
+		h.observe("isp", FamilyIPv4, &PingStats{Family: FamilyIPv4, Avg: float64Ptr(12)}, at)
+		h.observe("isp", FamilyIPv6, &PingStats{Family: FamilyIPv6, PacketLoss: 100}, at)
+	}
+	pings := drain(h)
+	if len(pings) != 2 || pings[0].url != "https://hc.example.com/uuid" || pings[1].url != "https://hc.example.com/uuid/fail" {
+		t.Fatalf("Expected the first IPv4 round and then a single failure, got %+v", pings)
+	}
+	if pings[1].body != "isp/IPv4: loss 0.0%, avg 12.000 ms\nisp/IPv6: loss 100.0%" {
+		t.Errorf("Expected both families in the body, got %q", pings[1].body)
+	}
+
+	h.observe("isp", FamilyIPv6, &PingStats{Family: FamilyIPv6, PacketLoss: 100}, now.Add(time.Minute))
+	if pings := drain(h); len(pings) != 1 || pings[0].url != "https://hc.example.com/uuid/fail" {
+		t.Fatalf("Expected one failure per interval, got %+v", pings)
+	}
+
+	// Recovery of the failing family is signalled straight away
+	h.observe("isp", FamilyIPv6, &PingStats{Family: FamilyIPv6, Avg: float64Ptr(14)}, now.Add(70*time.Second))
+	if pings := drain(h); len(pings) != 1 || pings[0].url != "https://hc.example.com/uuid" {
+		t.Fatalf("Expected the recovery to be signalled, got %+v", pings)
+	}
+}
+
+func TestHeartbeatSend(t *testing.T) {
+	server, requests := heartbeatStandIn(t)
+
+	h := newHeartbeat(HeartbeatConfig{URL: server.URL + "/ping/uuid", Method: http.MethodPost}, "isp")
+	go h.Run()
+	h.observe("isp", FamilyAny, &PingStats{PacketLoss: 20, Avg: float64Ptr(30)}, time.Now())
+
+	select {
+	case r := <-requests:
+		if r.method != http.MethodPost || r.path != "/ping/uuid" || r.body != "isp: loss 20.0%, avg 30.000 ms" {
+			t.Errorf("Unexpected request %+v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No heartbeat received")
+	}
+}
+
+func TestHeartbeatSendErrorsHideURL(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	h := newHeartbeat(HeartbeatConfig{URL: server.URL + "/ping/secret-uuid", Method: http.MethodGet}, "isp")
+	ping := heartbeatPing{url: server.URL + "/ping/secret-uuid"}
+	if err := h.send(ping); err == nil || !strings.Contains(err.Error(), "404") || strings.Contains(err.Error(), "secret") {
+		t.Errorf("Expected the status without the URL, got %v", err)
+	}
+
+	server.Close()
+	if err := h.send(ping); err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("Expected an error without the URL, got %v", err)
+	}
+}
+
+func TestHeartbeatConfigValidation(t *testing.T) {
+	for _, c := range []HeartbeatConfig{
+		{URL: "hc-ping.com/uuid"},
+		{URL: "https://hc-ping.com/uuid", Method: "PUT"},
+		{FailureURL: "ftp://example.com"},
+		{URL: "https://hc-ping.com/uuid", Interval: -time.Minute},
+	} {
+		if err := c.validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", c)
+		}
+	}
+}
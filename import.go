@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// importBatchSize is how many rounds each import transaction commits.
+const importBatchSize = 5000
+
+// importColumns lists, for each field an import can fill, the column names
+// recognised without a -map, in order of preference. SmokePing exports
+// name the average "median".
+var importColumns = map[string][]string{
+	"timestamp":   {"timestamp", "time", "date", "datetime"},
+	"target":      {"target", "host"},
+	"family":      {"family"},
+	"min":         {"min"},
+	"avg":         {"avg", "average", "mean", "median"},
+	"max":         {"max"},
+	"stddev":      {"stddev", "sd", "mdev"},
+	"packet_loss": {"packet_loss", "loss"},
+}
+
+// parseColumnMap parses -map "field=column,..." overrides.
+func parseColumnMap(s string) (map[string]string, error) {
+	columns := make(map[string]string)
+	if s == "" {
+		return columns, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		field, column, ok := strings.Cut(pair, "=")
+		field = strings.TrimSpace(field)
+		if _, known := importColumns[field]; !ok || !known {
+			return nil, fmt.Errorf("invalid mapping %q: expected field=column with a field from %s",
+				pair, strings.Join(importFieldNames(), ", "))
+		}
+		columns[field] = strings.ToLower(strings.TrimSpace(column))
+	}
+	return columns, nil
+}
+
+func importFieldNames() []string {
+	return []string{"timestamp", "target", "family", "min", "avg", "max", "stddev", "packet_loss"}
+}
+
+// importer converts records to rounds and saves them through a Store in
+// batches, skipping rounds already stored for the same series and time.
+type importer struct {
+	store   Store
+	columns map[string]string // Field to source column overrides
+	target  string            // Target for records without one
+	family  string            // Family for records without one
+
+	pending    []*PingStats
+	imported   int
+	duplicates int
+	invalid    int
+	oldest     time.Time // Oldest round imported
+}
+
+// column returns the source column for field among the record's columns.
+func (im *importer) column(field string, record map[string]string) (string, bool) {
+	if column, ok := im.columns[field]; ok {
+		value, found := record[column]
+		return value, found
+	}
+	for _, name := range importColumns[field] {
+		if value, ok := record[name]; ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// parseRecord converts a record, keyed by lower-case column name, to a round.
+func (im *importer) parseRecord(record map[string]string) (*PingStats, error) {
+	value, ok := im.column("timestamp", record)
+	if !ok {
+		return nil, fmt.Errorf("no timestamp column")
+	}
+	timestamp, err := parseImportTime(value)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &PingStats{Timestamp: timestamp, Target: im.target, Family: im.family}
+	if value, ok := im.column("target", record); ok && value != "" {
+		stats.Target = value
+	}
+	if value, ok := im.column("family", record); ok && value != "" {
+		stats.Family = value
+	}
+	if stats.Target == "" {
+		return nil, fmt.Errorf("no target")
+	}
+	if err := validateFamily(stats.Family); err != nil || stats.Family == FamilyBoth {
+		return nil, fmt.Errorf("invalid family %q", stats.Family)
+	}
+
+	latencies := []struct {
+		field string
+		dest  **float64
+	}{
+		{"min", &stats.Min}, {"avg", &stats.Avg}, {"max", &stats.Max}, {"stddev", &stats.StdDev},
+	}
+	for _, l := range latencies {
+		value, _ := im.column(l.field, record)
+		if *l.dest, err = parseImportNumber(value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", l.field, err)
+		}
+	}
+
+	value, _ = im.column("packet_loss", record)
+	loss, err := parseImportNumber(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid packet_loss: %v", err)
+	}
+	switch {
+	case loss != nil:
+		stats.PacketLoss = *loss
+	case stats.Avg == nil:
+		// No loss column and no latency: nothing came back
+		stats.PacketLoss = 100
+	}
+	if stats.PacketLoss < 0 || stats.PacketLoss > 100 {
+		return nil, fmt.Errorf("packet_loss %v is not a percentage", stats.PacketLoss)
+	}
+	return stats, nil
+}
+
+// parseImportTime accepts RFC 3339, the zoneless layouts taken as UTC, and
+// Unix epoch seconds or milliseconds.
+func parseImportTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if epoch, err := strconv.ParseFloat(s, 64); err == nil {
+		if math.Abs(epoch) > 1e12 {
+			return time.UnixMilli(int64(epoch)).UTC(), nil
+		}
+		return time.UnixMilli(int64(epoch * 1000)).UTC(), nil
+	}
+	if t, err := parseTimestamp(s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02 15:04:05", s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q", s)
+}
+
+// parseImportNumber returns nil for the empty, null and NaN values that
+// exports use for rounds without replies, including rrdtool's "U".
+func parseImportNumber(s string) (*float64, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "", "null", "nan", "-nan", "u":
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	if math.IsNaN(f) {
+		return nil, nil
+	}
+	return &f, nil
+}
+
+// add queues a record, committing a batch once enough are pending.
+func (im *importer) add(line int, record map[string]string) error {
+	stats, err := im.parseRecord(record)
+	if err != nil {
+		im.invalid++
+		log.Printf("Skipping line %d: %v", line, err)
+		return nil
+	}
+	im.pending = append(im.pending, stats)
+	if len(im.pending) >= importBatchSize {
+		return im.flush()
+	}
+	return nil
+}
+
+// flush saves the pending rounds in one transaction, leaving out any
+// already stored, or earlier in the batch, for the same series and time.
+func (im *importer) flush() error {
+	if len(im.pending) == 0 {
+		return nil
+	}
+
+	// Look up what's stored in the batch's time span, per target
+	type span struct{ first, last time.Time }
+	spans := make(map[string]*span)
+	for _, s := range im.pending {
+		sp, ok := spans[s.Target]
+		if !ok {
+			spans[s.Target] = &span{s.Timestamp, s.Timestamp}
+			continue
+		}
+		if s.Timestamp.Before(sp.first) {
+			sp.first = s.Timestamp
+		}
+		if s.Timestamp.After(sp.last) {
+			sp.last = s.Timestamp
+		}
+	}
+
+	// A dual-stack target has a round per family at the same time
+	type key struct {
+		target, family string
+		ms             int64
+	}
+	seen := make(map[key]bool)
+	for target, sp := range spans {
+		existing, err := im.store.StatsBetween(target, "", sp.first, sp.last)
+		if err != nil {
+			return err
+		}
+		for _, s := range existing {
+			seen[key{target, s.Family, toEpochMillis(s.Timestamp)}] = true
+		}
+	}
+
+	var batch []*PingStats
+	for _, s := range im.pending {
+		k := key{s.Target, s.Family, toEpochMillis(s.Timestamp)}
+		if seen[k] {
+			im.duplicates++
+			continue
+		}
+		seen[k] = true
+		batch = append(batch, s)
+		if im.oldest.IsZero() || s.Timestamp.Before(im.oldest) {
+			im.oldest = s.Timestamp
+		}
+	}
+
+	if len(batch) > 0 {
+		if err := im.store.SaveStats(batch...); err != nil {
+			return err
+		}
+	}
+	im.imported += len(batch)
+	im.pending = nil
+
+	log.Printf("Imported %d rounds (%d duplicates and %d invalid lines skipped)", im.imported, im.duplicates, im.invalid)
+	return nil
+}
+
+// importCSV reads a CSV file whose first row names the columns.
+func (im *importer) importCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read header: %v", err)
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := reader.FieldPos(0)
+
+		record := make(map[string]string, len(header))
+		for i, name := range header {
+			if i < len(fields) {
+				record[name] = fields[i]
+			}
+		}
+		if err := im.add(line, record); err != nil {
+			return err
+		}
+	}
+	return im.flush()
+}
+
+// importNDJSON reads one JSON object per line.
+func (im *importer) importNDJSON(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.UseNumber()
+		var object map[string]any
+		if err := decoder.Decode(&object); err != nil {
+			im.invalid++
+			log.Printf("Skipping line %d: %v", line, err)
+			continue
+		}
+
+		record := make(map[string]string, len(object))
+		for name, value := range object {
+			if value != nil {
+				record[strings.ToLower(name)] = fmt.Sprint(value)
+			} else {
+				record[strings.ToLower(name)] = ""
+			}
+		}
+		if err := im.add(line, record); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return im.flush()
+}
+
+// runImportCommand implements "pingo import <file>".
+func runImportCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "", "Input format: csv or ndjson (default: from the file extension)")
+	target := fs.String("target", "", "Target name for records without one (default: the first configured target)")
+	family := fs.String("family", "", "Address family for records without one: 4 or 6")
+	mapping := fs.String("map", "", "Column overrides as field=column,... e.g. avg=median,timestamp=time")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pingo import [options] <file>")
+		fmt.Fprintf(fs.Output(), "Fields: %s\n", strings.Join(importFieldNames(), ", "))
+		fs.PrintDefaults()
+	}
+	config, err := commandConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	path := fs.Arg(0)
+
+	if *format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".ndjson", ".jsonl":
+			*format = "ndjson"
+		default:
+			*format = "csv"
+		}
+	}
+	if *format != "csv" && *format != "ndjson" {
+		return fmt.Errorf("unknown format %q: must be csv or ndjson", *format)
+	}
+
+	if *family != FamilyIPv4 && *family != FamilyIPv6 && *family != FamilyAny {
+		return fmt.Errorf("invalid family %q: must be 4 or 6", *family)
+	}
+	columns, err := parseColumnMap(*mapping)
+	if err != nil {
+		return err
+	}
+	if *target == "" {
+		*target = config.monitoredTargets()[0].Name
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	db, err := openDB(config.DBPath, config.dbOptions())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	im := &importer{store: db, columns: columns, target: *target, family: *family}
+	if *format == "ndjson" {
+		err = im.importNDJSON(file)
+	} else {
+		err = im.importCSV(file)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Import complete: %d rounds imported, %d duplicates and %d invalid lines skipped",
+		im.imported, im.duplicates, im.invalid)
+
+	if !im.oldest.IsZero() {
+		if err := refreshSummariesSince(db, im.oldest); err != nil {
+			return fmt.Errorf("failed to update daily summaries: %v", err)
+		}
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -config.RetentionDays)
+	if !im.oldest.IsZero() && im.oldest.Before(cutoff) {
+		log.Printf("Warning: rounds older than retention_days (%d) will be pruned when pingo next runs maintenance; "+
+			"raise retention_days to keep them", config.RetentionDays)
+	}
+	return nil
+}
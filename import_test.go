@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportCSV(t *testing.T) {
+	store := newMemStore(100)
+	im := &importer{store: store, target: "isp"}
+
+	input := `time,median,loss,host
+# SmokePing-style export
+1760875200,12.5,0,
+1760875260,U,100,
+1760875260,U,100,
+2025-10-19T12:02:00+00:00,13.0,20,google
+not-a-time,1,0,
+`
+	if err := im.importCSV(strings.NewReader(input)); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	if im.imported != 3 || im.duplicates != 1 || im.invalid != 1 {
+		t.Errorf("Expected 3 imported, 1 duplicate, 1 invalid; got %d, %d, %d", im.imported, im.duplicates, im.invalid)
+	}
+
+	stats, _ := store.RecentStats("isp", "", 10)
+	if len(stats) != 2 {
+		t.Fatalf("Expected 2 rounds for isp, got %d", len(stats))
+	}
+	if !stats[0].Timestamp.Equal(time.Date(2025, 10, 19, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected epoch seconds to be read as UTC, got %v", stats[0].Timestamp)
+	}
+	if stats[0].Avg == nil || *stats[0].Avg != 12.5 {
+		t.Errorf("Expected median to map to avg, got %v", stats[0].Avg)
+	}
+	if stats[1].Avg != nil || stats[1].PacketLoss != 100 {
+		t.Errorf("Expected an unknown value to import as a failed round, got %+v", stats[1])
+	}
+
+	google, _ := store.RecentStats("google", "", 10)
+	if len(google) != 1 || google[0].PacketLoss != 20 {
+		t.Errorf("Expected the host column to set the target, got %+v", google)
+	}
+
+	// Importing the same file again adds nothing
+	again := &importer{store: store, target: "isp"}
+	if err := again.importCSV(strings.NewReader(input)); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if again.imported != 0 || again.duplicates != 4 {
+		t.Errorf("Expected every round to be a duplicate on re-import, got %d imported, %d duplicates",
+			again.imported, again.duplicates)
+	}
+
+	// A dual-stack target has a round per family at the same time
+	dual := `timestamp,target,family,avg
+2025-10-19T13:00:00Z,dns,4,8
+2025-10-19T13:00:00Z,dns,6,9
+2025-10-19T13:00:00Z,dns,6,9
+`
+	im = &importer{store: store}
+	if err := im.importCSV(strings.NewReader(dual)); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if im.imported != 2 || im.duplicates != 1 {
+		t.Errorf("Expected both families imported and 1 duplicate, got %d imported, %d duplicates",
+			im.imported, im.duplicates)
+	}
+	again = &importer{store: store}
+	if err := again.importCSV(strings.NewReader(dual)); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if again.imported != 0 || again.duplicates != 3 {
+		t.Errorf("Expected every dual-stack round to be a duplicate on re-import, got %d imported, %d duplicates",
+			again.imported, again.duplicates)
+	}
+}
+
+func TestImportNDJSON(t *testing.T) {
+	store := newMemStore(100)
+	columns, err := parseColumnMap("timestamp=ts, avg=rtt")
+	if err != nil {
+		t.Fatalf("Failed to parse mapping: %v", err)
+	}
+	im := &importer{store: store, columns: columns, target: "isp", family: FamilyIPv6}
+
+	input := `{"ts": 1760875200000, "rtt": 10.25, "min": 9, "max": 12, "stddev": null}
+{"ts": "2025-10-19T12:01:00Z", "rtt": null}
+
+{"ts": "2025-10-19T12:02:00Z", "rtt": 11, "family": "4"}
+{not json}
+`
+	if err := im.importNDJSON(strings.NewReader(input)); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if im.imported != 3 || im.invalid != 1 {
+		t.Errorf("Expected 3 imported and 1 invalid, got %d and %d", im.imported, im.invalid)
+	}
+
+	stats, _ := store.RecentStats("isp", FamilyIPv6, 10)
+	if len(stats) != 2 {
+		t.Fatalf("Expected 2 IPv6 rounds, got %d", len(stats))
+	}
+	if *stats[0].Avg != 10.25 || *stats[0].Min != 9 || stats[0].StdDev != nil {
+		t.Errorf("Unexpected first round: %+v", stats[0])
+	}
+	if !stats[0].Timestamp.Equal(time.Date(2025, 10, 19, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected epoch milliseconds, got %v", stats[0].Timestamp)
+	}
+	if stats[1].PacketLoss != 100 {
+		t.Errorf("Expected a round without latency to count as lost, got %v", stats[1].PacketLoss)
+	}
+}
+
+func TestParseColumnMapInvalid(t *testing.T) {
+	for _, input := range []string{"latency=avg", "avg"} {
+		if _, err := parseColumnMap(input); err == nil {
+			t.Errorf("parseColumnMap(%q): expected error, got nil", input)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// unixPrefix marks a listen address as a Unix socket path.
+const unixPrefix = "unix:"
+
+// listenFdsStart is the first file descriptor systemd passes with socket
+// activation.
+const listenFdsStart = 3
+
+// validateListenAddress accepts "host:port" or "unix:/path/to.sock".
+func validateListenAddress(address string) error {
+	if path, ok := strings.CutPrefix(address, unixPrefix); ok {
+		if path == "" {
+			return fmt.Errorf("invalid listen address %q: missing socket path", address)
+		}
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return fmt.Errorf("invalid listen address %q: %v", address, err)
+	}
+	return nil
+}
+
+// openListener listens on a TCP address or, with the unix: prefix, on a
+// Unix socket created with the given permissions.
+func openListener(address string, socketMode os.FileMode) (net.Listener, error) {
+	path, ok := strings.CutPrefix(address, unixPrefix)
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+
+	// Remove a socket left behind by a previous run, but nothing else
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, socketMode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// systemdListeners returns the sockets passed in by systemd socket
+// activation, or nil when pingo wasn't socket activated.
+func systemdListeners() ([]net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	// Don't pass the sockets on to child processes such as ping
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	var listeners []net.Listener
+	for fd := listenFdsStart; fd < listenFdsStart+count; fd++ {
+		file := os.NewFile(uintptr(fd), fmt.Sprintf("LISTEN_FD_%d", fd))
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("socket activation fd %d: %v", fd, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// openListeners returns the sockets systemd passed in, or else listens on
+// every configured address.
+func openListeners(addresses []string, socketMode os.FileMode) ([]net.Listener, error) {
+	listeners, err := systemdListeners()
+	if err != nil || listeners != nil {
+		return listeners, err
+	}
+
+	for _, address := range addresses {
+		listener, err := openListener(address, socketMode)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("failed to listen on %s: %v", address, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, listener := range listeners {
+		listener.Close()
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateListenAddress(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:7777", "[::1]:7777", ":7777", "unix:/run/pingo.sock"} {
+		if err := validateListenAddress(address); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", address, err)
+		}
+	}
+	for _, address := range []string{"7777", "::1:7777", "unix:", "localhost"} {
+		if err := validateListenAddress(address); err == nil {
+			t.Errorf("Expected %q to be rejected", address)
+		}
+	}
+}
+
+func TestListenAddresses(t *testing.T) {
+	config := getDefaultConfig()
+	if got := config.listenAddresses(); len(got) != 1 || got[0] != ":7777" {
+		t.Errorf("Expected the port on every interface by default, got %v", got)
+	}
+
+	config.Listen = []string{"127.0.0.1:8080", "unix:/run/pingo.sock"}
+	if got := config.listenAddresses(); len(got) != 2 || got[0] != "127.0.0.1:8080" {
+		t.Errorf("Expected the listen setting to replace the port, got %v", got)
+	}
+}
+
+func TestOpenUnixListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pingo.sock")
+
+	listener, err := openListener("unix:"+path, 0o660)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o660 {
+		t.Errorf("Expected mode 0660, got %#o", info.Mode().Perm())
+	}
+	if got := describeListener(listener); got != "unix:"+path {
+		t.Errorf("Expected %q, got %q", "unix:"+path, got)
+	}
+
+	// A socket left behind by a previous run is replaced
+	listener.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+	listener.Close()
+	listener, err = openListener("unix:"+path, 0o600)
+	if err != nil {
+		t.Fatalf("Failed to replace a stale socket: %v", err)
+	}
+	listener.Close()
+
+	// Anything else at the path is left alone
+	file := filepath.Join(t.TempDir(), "pingo.db")
+	if err := os.WriteFile(file, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openListener("unix:"+file, 0o660); err == nil {
+		t.Error("Expected an error when the path is not a socket")
+	}
+	if data, _ := os.ReadFile(file); string(data) != "data" {
+		t.Error("Expected the existing file to be kept")
+	}
+}
+
+func TestOpenListenersWithoutSocketActivation(t *testing.T) {
+	// LISTEN_FDS meant for another process is ignored
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+
+	listeners, err := openListeners([]string{"127.0.0.1:0", "127.0.0.1:0"}, 0o660)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer closeListeners(listeners)
+	if len(listeners) != 2 {
+		t.Errorf("Expected 2 listeners, got %d", len(listeners))
+	}
+
+	if _, err := openListeners([]string{"127.0.0.1:0", "192.0.2.1:bad"}, 0o660); err == nil {
+		t.Error("Expected an error for an address that can't be listened on")
+	}
+}
@@ -4,17 +4,55 @@ import (
 	"flag"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 )
 
+// commands are run with "pingo <command>" instead of starting the monitor.
+var commands = map[string]func(args []string) error{
+	"backup":  runBackupCommand,
+	"restore": runRestoreCommand,
+	"import":  runImportCommand,
+}
+
+// commandConfig parses a command's flags, adding the -config and -db flags
+// every command shares, and returns the loaded configuration.
+func commandConfig(fs *flag.FlagSet, args []string) (Config, error) {
+	configPath := fs.String("config", getDefaultConfigPath(), "Path to config file")
+	dbPath := fs.String("db", "", "Path to SQLite database file (overrides config)")
+	fs.Parse(args)
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return config, err
+	}
+	if *dbPath != "" {
+		config.DBPath = *dbPath
+	}
+	return config, nil
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatalf("%s failed: %v", os.Args[1], err)
+			}
+			return
+		}
+	}
+
 	// Define CLI flags
 	configPath := flag.String("config", getDefaultConfigPath(), "Path to config file")
 	port := flag.String("port", "", "Web server port (overrides config)")
 	retentionDays := flag.Int("retention", 0, "Number of days to retain ping data (overrides config)")
 	pingCount := flag.Int("pings", 0, "Number of pings per round (overrides config)")
 	target := flag.String("target", "", "Target host to ping (overrides config)")
+	family := flag.String("family", "", "Address family for the top-level target: 4, 6 or both (overrides config)")
 	dbPath := flag.String("db", "", "Path to SQLite database file (overrides config)")
+	ephemeral := flag.Bool("ephemeral", false, "Keep data in memory only, e.g. on a read-only file system")
 
 	flag.Parse()
 
@@ -35,30 +73,126 @@ func main() {
 		config.PingCount = *pingCount
 	}
 	if *target != "" {
+		// A single target on the command line replaces any configured [[targets]]
 		config.Target = *target
+		config.Targets = nil
+	}
+	if *family != "" {
+		config.Family = *family
 	}
 	if *dbPath != "" {
 		config.DBPath = *dbPath
 	}
 
-	// Ensure database directory exists
-	dbDir := filepath.Dir(config.DBPath)
-	if err := os.MkdirAll(dbDir, 0755); err != nil {
-		log.Fatalf("Failed to create database directory %s: %v", dbDir, err)
+	if err := config.validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
+	targets := config.monitoredTargets()
 
-	db, err := initDB(config.DBPath)
+	if *ephemeral {
+		config.DBPath = memoryDBPath
+	} else {
+		// Ensure database directory exists
+		dbDir := filepath.Dir(config.DBPath)
+		if err := os.MkdirAll(dbDir, 0755); err != nil {
+			log.Fatalf("Failed to create database directory %s: %v", dbDir, err)
+		}
+	}
+
+	db, err := openDB(config.DBPath, config.dbOptions())
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer db.Close()
 
-	log.Printf("Configuration: target=%s, pings=%d, retention=%d days, port=%s, db=%s",
-		config.Target, config.PingCount, config.RetentionDays, config.Port, config.DBPath)
+	// Ephemeral runs keep a bounded window of rounds in memory; PMTU and
+	// bufferbloat results go to the in-memory database
+	var store Store = db
+	if *ephemeral {
+		store = newMemStore(ephemeralCapacity)
+		log.Printf("Ephemeral mode: keeping the last %d rounds in memory, nothing is written to disk", ephemeralCapacity)
+	}
+
+	if err := adoptLegacyStats(db, targets[0].Name); err != nil {
+		log.Fatalf("Failed to update existing data: %v", err)
+	}
+
+	if err := refreshDailySummaries(db); err != nil {
+		log.Printf("Failed to update daily summaries: %v", err)
+	}
+	if !*ephemeral {
+		go runDailySummaries(db, dailySummaryInterval)
+	}
+
+	for _, t := range targets {
+		log.Printf("Target: name=%s, host=%s, family=%s", t.Name, t.Host, t.Family)
+	}
+	log.Printf("Configuration: pings=%d, retention=%d days, port=%s, db=%s",
+		config.PingCount, config.RetentionDays, config.Port, config.DBPath)
+
+	health := newMonitorHealth(time.Now())
+	writer := newStatsWriter(store, config.CommitInterval)
+	writer.health = health
+	if config.CommitInterval > 0 {
+		go writer.Run()
+	}
+
+	// Commit buffered rounds before exiting on SIGINT/SIGTERM
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %s, shutting down", sig)
+		sdNotify("STOPPING=1")
+		if err := writer.Flush(); err != nil {
+			log.Printf("Failed to commit buffered stats: %v", err)
+		}
+		db.Close()
+		os.Exit(0)
+	}()
 
 	// Run ping monitoring in background
-	go runPingMonitor(db, config.Target, config.PingCount, config.RetentionDays)
+	silences := newSilencer(db, config.Maintenance)
+	var alerts *alertManager
+	if len(config.Alerts) > 0 {
+		alerts = newAlertManager(config.Alerts, newNotifiers(config.Notifiers))
+		alerts.silencer = silences
+		log.Printf("Alerting: %d rules, %d notifiers, %d maintenance windows",
+			len(config.Alerts), len(config.Notifiers), len(config.Maintenance))
+	}
+
+	var heartbeat *heartbeat
+	if config.Heartbeat.enabled() {
+		target, _ := findTarget(targets, config.Heartbeat.Target)
+		heartbeat = newHeartbeat(config.Heartbeat, target.Name)
+		go heartbeat.Run()
+	}
+
+	var anomalies *anomalyDetector
+	if config.Anomaly.enabled() {
+		anomalies = newAnomalyDetector(store, config.Anomaly, listSeries(targets))
+		go anomalies.Run()
+	}
+
+	go runPingMonitor(writer, health, alerts, heartbeat, anomalies, targets, config.PingCount)
+	if interval := watchdogInterval(); interval > 0 {
+		go runWatchdog(health, listSeries(targets), config.readyMaxAge(), interval)
+	}
+	go runMaintenance(db, store, config.RetentionDays, config.maxDBBytes(), config.MaintenanceInterval, config.IncrementalVacuum)
+	go runPMTUMonitor(db, targets)
+	if config.BackupInterval > 0 && !*ephemeral {
+		go runBackups(db, config.backupDir(), config.BackupInterval, config.BackupKeep)
+	}
+
+	var bufferbloat *bufferbloatRunner
+	if config.Bufferbloat.enabled() {
+		target, _ := findTarget(targets, config.Bufferbloat.Target)
+		bufferbloat = newBufferbloatRunner(db, config.Bufferbloat, target)
+		if config.Bufferbloat.Interval > 0 {
+			go runBufferbloatSchedule(bufferbloat, config.Bufferbloat.Interval)
+		}
+	}
 
 	// Start web server (blocks)
-	startWebServer(db, config.Port)
+	startWebServer(db, store, config, targets, bufferbloat, health, silences, anomalies)
 }
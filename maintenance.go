@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// pruneBatchSize bounds how many rows a single DELETE removes, so pruning
+// a large backlog doesn't hold the write lock for long.
+const pruneBatchSize = 1000
+
+// prunedTables are the tables whose rows expire with the retention period.
+var prunedTables = []string{"ping_stats", "pmtu_results", "bufferbloat_results"}
+
+// pruneTable deletes rows older than cutoff in batches of batchSize and
+// returns the number of rows removed.
+func pruneTable(db *DB, table string, cutoff time.Time, batchSize int) (int64, error) {
+	deleteSQL := fmt.Sprintf(`DELETE FROM %s WHERE id IN (SELECT id FROM %s WHERE timestamp < ? LIMIT ?)`, table, table)
+
+	var total int64
+	for {
+		result, err := db.Exec(deleteSQL, toEpochMillis(cutoff), batchSize)
+		if err != nil {
+			return total, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+// pruneOldStats deletes every stored result older than cutoff.
+func pruneOldStats(db *DB, cutoff time.Time, batchSize int) (int64, error) {
+	var total int64
+	for _, table := range prunedTables {
+		n, err := pruneTable(db, table, cutoff, batchSize)
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("failed to prune %s: %v", table, err)
+		}
+	}
+	return total, nil
+}
+
+// sizeLimitOrder lists tables in the order the size cap deletes from them:
+// raw rounds go first, the much smaller discovery and test results last.
+var sizeLimitOrder = []string{"ping_stats", "pmtu_results", "bufferbloat_results"}
+
+// enforceSizeLimit deletes the oldest rows, batch by batch, until the
+// database's used pages fit in maxBytes or there's nothing left to delete.
+// Freed pages are reused by SQLite, so the file stops growing; it only
+// shrinks once vacuumed.
+func enforceSizeLimit(db *DB, maxBytes int64, batchSize int) (int64, error) {
+	var total int64
+	for _, table := range sizeLimitOrder {
+		deleteSQL := fmt.Sprintf(`DELETE FROM %s WHERE id IN (SELECT id FROM %s ORDER BY timestamp ASC LIMIT ?)`, table, table)
+		for {
+			size, free, err := databaseSize(db)
+			if err != nil {
+				return total, err
+			}
+			if size-free <= maxBytes {
+				return total, nil
+			}
+
+			result, err := db.Exec(deleteSQL, batchSize)
+			if err != nil {
+				return total, fmt.Errorf("failed to trim %s: %v", table, err)
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return total, err
+			}
+			total += n
+			if n == 0 {
+				break
+			}
+		}
+	}
+	return total, nil
+}
+
+// StorageReport describes how much space the database uses and, given the
+// current growth rate, how long until the size cap is reached.
+type StorageReport struct {
+	SizeBytes     int64      `json:"size_bytes"`     // Size of the database file
+	FreeBytes     int64      `json:"free_bytes"`     // Free pages a vacuum would release
+	UsedBytes     int64      `json:"used_bytes"`     // SizeBytes minus FreeBytes
+	MaxBytes      int64      `json:"max_bytes"`      // 0 when no size cap is set
+	RetentionDays int        `json:"retention_days"` // Age limit applied by maintenance
+	Rows          int64      `json:"rows"`           // Stored ping rounds
+	Oldest        *time.Time `json:"oldest"`
+	Newest        *time.Time `json:"newest"`
+	BytesPerDay   *float64   `json:"bytes_per_day"` // Growth rate estimated from the stored span
+	HeadroomDays  *float64   `json:"headroom_days"` // Days until the size cap is reached
+}
+
+func getStorageReport(db *DB, maxBytes int64, retentionDays int) (*StorageReport, error) {
+	size, free, err := databaseSize(db)
+	if err != nil {
+		return nil, err
+	}
+	report := &StorageReport{
+		SizeBytes:     size,
+		FreeBytes:     free,
+		UsedBytes:     size - free,
+		MaxBytes:      maxBytes,
+		RetentionDays: retentionDays,
+	}
+
+	err = db.QueryRow(`SELECT COUNT(*) FROM ping_stats`).Scan(&report.Rows)
+	if err != nil {
+		return nil, err
+	}
+	if report.Rows == 0 {
+		return report, nil
+	}
+
+	var oldestMs, newestMs int64
+	err = db.QueryRow(`SELECT MIN(timestamp), MAX(timestamp) FROM ping_stats`).Scan(&oldestMs, &newestMs)
+	if err != nil {
+		return nil, err
+	}
+	oldest, newest := fromEpochMillis(oldestMs), fromEpochMillis(newestMs)
+	report.Oldest, report.Newest = &oldest, &newest
+
+	// Need at least an hour of data for a meaningful growth rate
+	span := newest.Sub(oldest)
+	if span < time.Hour {
+		return report, nil
+	}
+	bytesPerDay := float64(report.UsedBytes) / (span.Hours() / 24)
+	report.BytesPerDay = &bytesPerDay
+
+	if maxBytes > 0 {
+		headroom := max(float64(maxBytes-report.UsedBytes), 0) / bytesPerDay
+		report.HeadroomDays = &headroom
+	}
+	return report, nil
+}
+
+// databaseSize returns the size of the database file in bytes and how many
+// of those bytes are free pages that a vacuum would return to the OS.
+func databaseSize(db *DB) (size int64, free int64, err error) {
+	var pageCount, pageSize, freelistCount int64
+	if err := db.QueryRow(`PRAGMA page_count`).Scan(&pageCount); err != nil {
+		return 0, 0, err
+	}
+	if err := db.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, 0, err
+	}
+	if err := db.QueryRow(`PRAGMA freelist_count`).Scan(&freelistCount); err != nil {
+		return 0, 0, err
+	}
+	return pageCount * pageSize, freelistCount * pageSize, nil
+}
+
+// incrementalVacuum releases free pages back to the file system. Databases
+// not yet in incremental auto-vacuum mode are converted first, which
+// requires a one-off full VACUUM.
+func incrementalVacuum(db *DB) error {
+	ctx := context.Background()
+
+	// auto_vacuum only takes effect after a VACUUM on the same connection
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var mode int
+	if err := conn.QueryRowContext(ctx, `PRAGMA auto_vacuum`).Scan(&mode); err != nil {
+		return err
+	}
+
+	const autoVacuumIncremental = 2
+	if mode != autoVacuumIncremental {
+		log.Printf("Maintenance: enabling incremental auto-vacuum (one-time full VACUUM)...")
+		if _, err := conn.ExecContext(ctx, `PRAGMA auto_vacuum = INCREMENTAL`); err != nil {
+			return err
+		}
+		if _, err := conn.ExecContext(ctx, `VACUUM`); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	_, err = conn.ExecContext(ctx, `PRAGMA incremental_vacuum`)
+	return err
+}
+
+// runMaintenance prunes expired data on startup and then every interval,
+// keeping deletes off the monitor's insert path.
+func runMaintenance(db *DB, store Store, retentionDays int, maxBytes int64, interval time.Duration, vacuum bool) {
+	for {
+		runMaintenanceOnce(db, store, retentionDays, maxBytes, vacuum)
+		time.Sleep(interval)
+	}
+}
+
+func runMaintenanceOnce(db *DB, store Store, retentionDays int, maxBytes int64, vacuum bool) {
+	start := time.Now()
+	cutoff := start.AddDate(0, 0, -retentionDays)
+
+	removed, err := store.PruneStats(cutoff)
+	if err != nil {
+		log.Printf("Maintenance: %v", err)
+	}
+	if store != Store(db) {
+		// Ephemeral rounds are kept outside the database, but PMTU and
+		// bufferbloat results still expire from it
+		n, err := pruneOldStats(db, cutoff, pruneBatchSize)
+		if err != nil {
+			log.Printf("Maintenance: %v", err)
+		}
+		removed += n
+	}
+
+	if maxBytes > 0 {
+		trimmed, err := enforceSizeLimit(db, maxBytes, pruneBatchSize)
+		if err != nil {
+			log.Printf("Maintenance: %v", err)
+		}
+		if trimmed > 0 {
+			log.Printf("Maintenance: removed %d oldest rows to stay under %.1f MB", trimmed, float64(maxBytes)/(1<<20))
+		}
+	}
+
+	if vacuum {
+		if err := incrementalVacuum(db); err != nil {
+			log.Printf("Maintenance: incremental vacuum failed: %v", err)
+		}
+	}
+
+	size, free, err := databaseSize(db)
+	if err != nil {
+		log.Printf("Maintenance: failed to read database size: %v", err)
+		return
+	}
+
+	log.Printf("Maintenance: removed %d rows older than %d days in %s; database size %.1f MB (%.1f MB free)",
+		removed, retentionDays, time.Since(start).Round(time.Millisecond),
+		float64(size)/(1<<20), float64(free)/(1<<20))
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPruneOldStatsBatches(t *testing.T) {
+	db, err := initDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	oldTime := time.Now().AddDate(0, 0, -40)
+	for i := 0; i < 25; i++ {
+		if err := savePingStats(db, &PingStats{Timestamp: oldTime.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatalf("Failed to save old data: %v", err)
+		}
+	}
+	if err := savePingStats(db, &PingStats{Timestamp: time.Now()}); err != nil {
+		t.Fatalf("Failed to save recent data: %v", err)
+	}
+	if _, err := recordPMTU(db, "isp", "", 1500, oldTime); err != nil {
+		t.Fatalf("Failed to save old path MTU: %v", err)
+	}
+
+	// A batch size smaller than the backlog needs several DELETEs
+	removed, err := pruneOldStats(db, time.Now().AddDate(0, 0, -30), 10)
+	if err != nil {
+		t.Fatalf("Failed to prune old data: %v", err)
+	}
+	if removed != 26 {
+		t.Errorf("Expected 26 rows removed across tables, got %d", removed)
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM ping_stats`).Scan(&count); err != nil {
+		t.Fatalf("Failed to count rows: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 remaining row, got %d", count)
+	}
+}
+
+func TestIncrementalVacuum(t *testing.T) {
+	db, err := initDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	// The first run converts the database, later runs just vacuum
+	for i := 0; i < 2; i++ {
+		if err := incrementalVacuum(db); err != nil {
+			t.Fatalf("Incremental vacuum failed (run %d): %v", i+1, err)
+		}
+	}
+
+	var mode int
+	if err := db.QueryRow(`PRAGMA auto_vacuum`).Scan(&mode); err != nil {
+		t.Fatalf("Failed to read auto_vacuum: %v", err)
+	}
+	if mode != 2 {
+		t.Errorf("Expected auto_vacuum = 2 (incremental), got %d", mode)
+	}
+
+	size, _, err := databaseSize(db)
+	if err != nil {
+		t.Fatalf("Failed to read database size: %v", err)
+	}
+	if size <= 0 {
+		t.Errorf("Expected a positive database size, got %d", size)
+	}
+}
+
+func TestEnforceSizeLimit(t *testing.T) {
+	db, err := initDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	// A few days of rounds, inserted in one transaction to keep the test fast
+	baseTime := time.Now().AddDate(0, 0, -5).Truncate(time.Millisecond)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	for i := 0; i < 5000; i++ {
+		_, err := tx.Exec(`INSERT INTO ping_stats (timestamp, avg, target) VALUES (?, ?, ?)`,
+			toEpochMillis(baseTime.Add(time.Duration(i)*time.Minute)), 10.0, "isp")
+		if err != nil {
+			t.Fatalf("Failed to insert test data: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit test data: %v", err)
+	}
+
+	size, free, err := databaseSize(db)
+	if err != nil {
+		t.Fatalf("Failed to read database size: %v", err)
+	}
+	budget := (size - free) / 2
+
+	removed, err := enforceSizeLimit(db, budget, 500)
+	if err != nil {
+		t.Fatalf("Failed to enforce size limit: %v", err)
+	}
+	if removed == 0 || removed == 5000 {
+		t.Errorf("Expected some but not all rows removed, got %d", removed)
+	}
+
+	size, free, err = databaseSize(db)
+	if err != nil {
+		t.Fatalf("Failed to read database size: %v", err)
+	}
+	if size-free > budget {
+		t.Errorf("Expected used size under %d bytes, got %d", budget, size-free)
+	}
+
+	// The oldest rows go first
+	stats, err := getRecentStats(db, "isp", "", 1)
+	if err != nil {
+		t.Fatalf("Failed to get recent stats: %v", err)
+	}
+	if len(stats) != 1 || !stats[0].Timestamp.Equal(baseTime.Add(4999*time.Minute)) {
+		t.Errorf("Expected the newest row to survive, got %+v", stats)
+	}
+}
+
+func TestGetStorageReport(t *testing.T) {
+	db, err := initDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	// Empty database: sizes only
+	report, err := getStorageReport(db, 10<<20, 15)
+	if err != nil {
+		t.Fatalf("Failed to get storage report: %v", err)
+	}
+	if report.Rows != 0 || report.HeadroomDays != nil {
+		t.Errorf("Expected no rows and no headroom estimate, got %+v", report)
+	}
+
+	baseTime := time.Now().AddDate(0, 0, -2)
+	for i := 0; i < 3; i++ {
+		if err := savePingStats(db, &PingStats{Timestamp: baseTime.Add(time.Duration(i) * 24 * time.Hour)}); err != nil {
+			t.Fatalf("Failed to save test data: %v", err)
+		}
+	}
+
+	report, err = getStorageReport(db, 10<<20, 15)
+	if err != nil {
+		t.Fatalf("Failed to get storage report: %v", err)
+	}
+	if report.Rows != 3 {
+		t.Errorf("Expected 3 rows, got %d", report.Rows)
+	}
+	if report.BytesPerDay == nil || *report.BytesPerDay != float64(report.UsedBytes)/2 {
+		t.Errorf("Expected growth of used bytes over 2 days, got %v", report.BytesPerDay)
+	}
+	if report.HeadroomDays == nil || *report.HeadroomDays <= 0 {
+		t.Errorf("Expected positive headroom, got %v", report.HeadroomDays)
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// migration upgrades the schema by one version. Migrations run in order,
+// each in its own transaction, and the database's PRAGMA user_version
+// records the last one applied.
+//
+// Databases created before versioning have user_version 0 and may be in
+// any of the historical layouts, so early migrations check what exists
+// rather than assuming an empty database.
+type migration struct {
+	name string
+	up   func(tx *sql.Tx) error
+}
+
+// migrations must only ever be appended to; a migration's position in
+// the list is its version number.
+var migrations = []migration{
+	{"create ping_stats", migrateCreatePingStats},
+	{"add target and family", migrateAddTargetFamily},
+	{"add probe parameters", migrateAddProbeParameters},
+	{"create pmtu_results", migrateCreatePMTUResults},
+	{"create bufferbloat_results", migrateCreateBufferbloatResults},
+	{"store timestamps as epoch milliseconds", migrateEpochTimestamps},
+	{"create daily_summary", migrateCreateDailySummary},
+	{"create annotations", migrateCreateAnnotations},
+	{"create silences", migrateCreateSilences},
+	{"add anomaly score", migrateAddAnomalyScore},
+	{"count replies in daily_summary", migrateAddDailySummaryReplies},
+}
+
+// schemaVersion is the version a fully migrated database is at.
+func schemaVersion() int {
+	return len(migrations)
+}
+
+func getSchemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow(`PRAGMA user_version`).Scan(&version)
+	return version, err
+}
+
+// migrateDB applies any pending migrations. It refuses to touch a database
+// written by a newer version of pingo, whose schema it doesn't understand.
+func migrateDB(db *sql.DB) error {
+	version, err := getSchemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	if version > schemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than this version of pingo supports (%d); please upgrade pingo",
+			version, schemaVersion())
+	}
+
+	for i := version; i < len(migrations); i++ {
+		m := migrations[i]
+		log.Printf("Migrating database schema to version %d (%s)...", i+1, m.name)
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := m.up(tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s) failed: %v", i+1, m.name, err)
+		}
+		// PRAGMA doesn't accept bound parameters
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record schema version %d: %v", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %v", i+1, m.name, err)
+		}
+	}
+
+	return nil
+}
+
+// tableColumns returns the columns of a table mapped to whether they are
+// declared NOT NULL. The map is empty if the table doesn't exist.
+func tableColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid          int
+			name, ctype  string
+			notNull      bool
+			defaultValue sql.NullString
+			pk           int
+		)
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = notNull
+	}
+	return columns, rows.Err()
+}
+
+// addColumnIfMissing adds a column unless an unversioned database already
+// has it from an earlier ad-hoc upgrade.
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	columns, err := tableColumns(tx, table)
+	if err != nil {
+		return err
+	}
+	if _, ok := columns[column]; ok {
+		return nil
+	}
+	_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	return err
+}
+
+// migrateCreatePingStats creates the ping_stats table, or brings one from
+// an early release up to date: the packet_loss column was added after the
+// first release, and min/avg/max/stddev used to be NOT NULL, which left no
+// way to record rounds where every packet was lost.
+func migrateCreatePingStats(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS ping_stats (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp DATETIME NOT NULL,
+			min REAL,
+			avg REAL,
+			max REAL,
+			stddev REAL,
+			packet_loss REAL DEFAULT 0
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	if err := addColumnIfMissing(tx, "ping_stats", "packet_loss", "REAL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	columns, err := tableColumns(tx, "ping_stats")
+	if err != nil {
+		return err
+	}
+
+	// SQLite doesn't support ALTER COLUMN to drop NOT NULL, so recreate the table
+	if columns["min"] {
+		log.Printf("Recreating ping_stats to allow NULL values for ping failures...")
+
+		_, err = tx.Exec(`
+			CREATE TABLE ping_stats_new (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				timestamp DATETIME NOT NULL,
+				min REAL,
+				avg REAL,
+				max REAL,
+				stddev REAL,
+				packet_loss REAL DEFAULT 0
+			)
+		`)
+		if err != nil {
+			return fmt.Errorf("failed to create new table: %v", err)
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO ping_stats_new (id, timestamp, min, avg, max, stddev, packet_loss)
+			SELECT id, timestamp, min, avg, max, stddev, COALESCE(packet_loss, 0)
+			FROM ping_stats
+		`)
+		if err != nil {
+			return fmt.Errorf("failed to copy data: %v", err)
+		}
+
+		if _, err = tx.Exec(`DROP TABLE ping_stats`); err != nil {
+			return fmt.Errorf("failed to drop old table: %v", err)
+		}
+		if _, err = tx.Exec(`ALTER TABLE ping_stats_new RENAME TO ping_stats`); err != nil {
+			return fmt.Errorf("failed to rename table: %v", err)
+		}
+	}
+
+	_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS idx_ping_stats_timestamp ON ping_stats(timestamp)`)
+	return err
+}
+
+func migrateAddTargetFamily(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "ping_stats", "target", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(tx, "ping_stats", "family", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_ping_stats_target_timestamp ON ping_stats(target, family, timestamp)`)
+	return err
+}
+
+func migrateAddProbeParameters(tx *sql.Tx) error {
+	columns := []struct{ name, definition string }{
+		{"payload_size", "INTEGER NOT NULL DEFAULT 0"},
+		{"dont_fragment", "INTEGER NOT NULL DEFAULT 0"},
+		{"ttl", "INTEGER NOT NULL DEFAULT 0"},
+		{"tos", "INTEGER NOT NULL DEFAULT 0"},
+		{"source", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(tx, "ping_stats", c.name, c.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateCreatePMTUResults(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS pmtu_results (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp DATETIME NOT NULL,
+			target TEXT NOT NULL,
+			family TEXT NOT NULL DEFAULT '',
+			mtu INTEGER NOT NULL,
+			previous_mtu INTEGER NOT NULL DEFAULT 0,
+			changed INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE INDEX IF NOT EXISTS idx_pmtu_results_target_timestamp ON pmtu_results(target, family, timestamp);
+	`)
+	return err
+}
+
+func migrateCreateBufferbloatResults(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS bufferbloat_results (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp DATETIME NOT NULL,
+			target TEXT NOT NULL,
+			family TEXT NOT NULL DEFAULT '',
+			idle_latency REAL,
+			download_latency REAL,
+			upload_latency REAL,
+			download_delta REAL,
+			upload_delta REAL,
+			download_mbps REAL NOT NULL DEFAULT 0,
+			upload_mbps REAL NOT NULL DEFAULT 0,
+			grade TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_bufferbloat_results_timestamp ON bufferbloat_results(timestamp);
+	`)
+	return err
+}
+
+// migrateEpochTimestamps converts timestamps from the driver's text format,
+// written in the server's local time, to UTC epoch milliseconds. The text
+// compared as strings, so rows from either side of a DST change, or from
+// before the server's timezone changed, sorted and filtered incorrectly.
+//
+// The columns keep their DATETIME declaration; its NUMERIC affinity stores
+// the integers as they are.
+func migrateEpochTimestamps(tx *sql.Tx) error {
+	for _, table := range []string{"ping_stats", "pmtu_results", "bufferbloat_results"} {
+		if err := convertTimestamps(tx, table); err != nil {
+			return fmt.Errorf("failed to convert %s timestamps: %v", table, err)
+		}
+	}
+	return nil
+}
+
+func convertTimestamps(tx *sql.Tx, table string) error {
+	rows, err := tx.Query(fmt.Sprintf(`SELECT id, timestamp FROM %s WHERE typeof(timestamp) != 'integer'`, table))
+	if err != nil {
+		return err
+	}
+
+	// Read everything first; the transaction's connection can't run the
+	// updates while the query is still open
+	converted := make(map[int64]int64)
+	for rows.Next() {
+		var id int64
+		var value any
+		if err := rows.Scan(&id, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		ms, err := legacyTimestampMillis(value)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("row %d: %v", id, err)
+		}
+		converted[id] = ms
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(converted) == 0 {
+		return nil
+	}
+	log.Printf("Converting %d %s timestamps to UTC...", len(converted), table)
+
+	stmt, err := tx.Prepare(fmt.Sprintf(`UPDATE %s SET timestamp = ? WHERE id = ?`, table))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for id, ms := range converted {
+		if _, err := stmt.Exec(ms, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// legacyTimestampMillis converts a DATETIME value as read back by the
+// driver, which parses both the time.Time.String() text it used to write
+// (including its UTC offset) and SQLite's own formats (taken as UTC).
+func legacyTimestampMillis(value any) (int64, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return toEpochMillis(v), nil
+	}
+	return 0, fmt.Errorf("unrecognized timestamp %v", value)
+}
+
+// migrateCreateDailySummary creates the per-day summary table. Summaries of
+// existing history are computed by refreshDailySummaries on startup.
+func migrateCreateDailySummary(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS daily_summary (
+			target TEXT NOT NULL,
+			family TEXT NOT NULL DEFAULT '',
+			day TEXT NOT NULL,
+			rounds INTEGER NOT NULL,
+			availability REAL NOT NULL,
+			packet_loss REAL NOT NULL,
+			avg REAL,
+			p95 REAL,
+			worst_hour INTEGER,
+			worst_hour_loss REAL,
+			worst_hour_avg REAL,
+			PRIMARY KEY (target, family, day)
+		)
+	`)
+	return err
+}
+
+func migrateCreateAnnotations(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS annotations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			time INTEGER NOT NULL,
+			end_time INTEGER,
+			text TEXT NOT NULL,
+			tags TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX IF NOT EXISTS idx_annotations_time ON annotations(time);
+	`)
+	return err
+}
+
+func migrateCreateSilences(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS silences (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			target TEXT NOT NULL DEFAULT '',
+			rule TEXT NOT NULL DEFAULT '',
+			starts INTEGER NOT NULL,
+			expires INTEGER NOT NULL,
+			comment TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX IF NOT EXISTS idx_silences_expires ON silences(expires);
+	`)
+	return err
+}
+
+// migrateAddAnomalyScore records how far each round's latency was from the
+// series' baseline. Existing rounds have no score.
+func migrateAddAnomalyScore(tx *sql.Tx) error {
+	return addColumnIfMissing(tx, "ping_stats", "anomaly_score", "REAL")
+}
+
+// migrateAddDailySummaryReplies counts the rounds behind each summary's
+// mean latency, so the mean can be updated as rounds are saved. The
+// current day is recomputed, with its count, on startup.
+func migrateAddDailySummaryReplies(tx *sql.Tx) error {
+	return addColumnIfMissing(tx, "daily_summary", "replies", "INTEGER NOT NULL DEFAULT 0")
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// historicalSchemas are the ping_stats layouts found in unversioned
+// databases written by earlier releases.
+var historicalSchemas = []struct {
+	name   string
+	schema string
+	insert string
+}{
+	{
+		name: "original NOT NULL columns",
+		schema: `CREATE TABLE ping_stats (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp DATETIME NOT NULL,
+			min REAL NOT NULL,
+			avg REAL NOT NULL,
+			max REAL NOT NULL,
+			stddev REAL NOT NULL
+		)`,
+		insert: `INSERT INTO ping_stats (timestamp, min, avg, max, stddev) VALUES ('2025-10-19 12:00:00 +0000 UTC', 10, 12, 15, 2)`,
+	},
+	{
+		name: "NOT NULL columns with packet_loss",
+		schema: `CREATE TABLE ping_stats (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp DATETIME NOT NULL,
+			min REAL NOT NULL,
+			avg REAL NOT NULL,
+			max REAL NOT NULL,
+			stddev REAL NOT NULL
+		);
+		ALTER TABLE ping_stats ADD COLUMN packet_loss REAL DEFAULT 0`,
+		insert: `INSERT INTO ping_stats (timestamp, min, avg, max, stddev, packet_loss) VALUES ('2025-10-19 12:00:00 +0000 UTC', 10, 12, 15, 2, 0)`,
+	},
+	{
+		name: "nullable columns",
+		schema: `CREATE TABLE ping_stats (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp DATETIME NOT NULL,
+			min REAL,
+			avg REAL,
+			max REAL,
+			stddev REAL,
+			packet_loss REAL DEFAULT 0
+		);
+		CREATE INDEX idx_ping_stats_timestamp ON ping_stats(timestamp)`,
+		insert: `INSERT INTO ping_stats (timestamp, min, avg, max, stddev, packet_loss) VALUES ('2025-10-19 12:00:00 +0000 UTC', 10, 12, 15, 2, 0)`,
+	},
+	{
+		name: "with target, family and probe columns",
+		schema: `CREATE TABLE ping_stats (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp DATETIME NOT NULL,
+			min REAL,
+			avg REAL,
+			max REAL,
+			stddev REAL,
+			packet_loss REAL DEFAULT 0,
+			target TEXT NOT NULL DEFAULT '',
+			family TEXT NOT NULL DEFAULT '',
+			payload_size INTEGER NOT NULL DEFAULT 0,
+			dont_fragment INTEGER NOT NULL DEFAULT 0,
+			ttl INTEGER NOT NULL DEFAULT 0,
+			tos INTEGER NOT NULL DEFAULT 0,
+			source TEXT NOT NULL DEFAULT ''
+		);
+		CREATE TABLE pmtu_results (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp DATETIME NOT NULL,
+			target TEXT NOT NULL,
+			family TEXT NOT NULL DEFAULT '',
+			mtu INTEGER NOT NULL,
+			previous_mtu INTEGER NOT NULL DEFAULT 0,
+			changed INTEGER NOT NULL DEFAULT 0
+		)`,
+		insert: `INSERT INTO ping_stats (timestamp, min, avg, max, stddev, packet_loss, target) VALUES ('2025-10-19 12:00:00 +0000 UTC', 10, 12, 15, 2, 0, 'google')`,
+	},
+}
+
+func TestMigrateHistoricalSchemas(t *testing.T) {
+	for _, hs := range historicalSchemas {
+		t.Run(hs.name, func(t *testing.T) {
+			dbPath := filepath.Join(t.TempDir(), "test.db")
+
+			raw, err := sql.Open("sqlite", dbPath)
+			if err != nil {
+				t.Fatalf("Failed to open database: %v", err)
+			}
+			if _, err := raw.Exec(hs.schema); err != nil {
+				t.Fatalf("Failed to create historical schema: %v", err)
+			}
+			if _, err := raw.Exec(hs.insert); err != nil {
+				t.Fatalf("Failed to insert historical row: %v", err)
+			}
+			raw.Close()
+
+			db, err := initDB(dbPath)
+			if err != nil {
+				t.Fatalf("Failed to migrate database: %v", err)
+			}
+			defer db.Close()
+
+			version, err := getSchemaVersion(db.DB)
+			if err != nil {
+				t.Fatalf("Failed to read schema version: %v", err)
+			}
+			if version != schemaVersion() {
+				t.Errorf("Expected schema version %d, got %d", schemaVersion(), version)
+			}
+
+			// Existing data survives and failed rounds can now be stored
+			stats, err := getRecentStats(db, "", "", 10)
+			if err != nil {
+				t.Fatalf("Failed to query migrated data: %v", err)
+			}
+			if len(stats) != 1 || stats[0].Min == nil || *stats[0].Min != 10 {
+				t.Fatalf("Expected the historical row to survive, got %+v", stats)
+			}
+			if _, err := db.Exec(`INSERT INTO ping_stats (timestamp, packet_loss) VALUES (1760875205000, 100)`); err != nil {
+				t.Errorf("Expected NULL latency to be allowed after migration: %v", err)
+			}
+
+			assertMigratedSchema(t, db)
+		})
+	}
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	for i := 0; i < 2; i++ {
+		db, err := initDB(dbPath)
+		if err != nil {
+			t.Fatalf("Failed to initialize database (run %d): %v", i+1, err)
+		}
+		assertMigratedSchema(t, db)
+		db.Close()
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	raw, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if _, err := raw.Exec(`PRAGMA user_version = 9999`); err != nil {
+		t.Fatalf("Failed to set schema version: %v", err)
+	}
+	raw.Close()
+
+	_, err = initDB(dbPath)
+	if err == nil {
+		t.Fatal("Expected error for a database from a newer version, got nil")
+	}
+	if !strings.Contains(err.Error(), "newer") {
+		t.Errorf("Expected error to mention the newer schema, got: %v", err)
+	}
+}
+
+// assertMigratedSchema checks that every table and column added by the
+// migrations is present.
+func assertMigratedSchema(t *testing.T, db *DB) {
+	t.Helper()
+
+	expected := map[string][]string{
+		"ping_stats":          {"timestamp", "min", "packet_loss", "target", "family", "payload_size", "source", "anomaly_score"},
+		"pmtu_results":        {"mtu", "previous_mtu", "changed"},
+		"bufferbloat_results": {"idle_latency", "grade"},
+		"daily_summary":       {"day", "rounds", "availability", "p95", "worst_hour"},
+		"annotations":         {"time", "end_time", "text", "tags"},
+		"silences":            {"target", "rule", "starts", "expires", "comment"},
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for table, columns := range expected {
+		got, err := tableColumns(tx, table)
+		if err != nil {
+			t.Fatalf("Failed to read columns of %s: %v", table, err)
+		}
+		for _, column := range columns {
+			if _, ok := got[column]; !ok {
+				t.Errorf("Expected column %s.%s after migration", table, column)
+			}
+		}
+	}
+}
+
+func TestMigrateTextTimestampsAcrossDST(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	raw, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	// Written by the driver in local time either side of the fall-back
+	// change: as text, the later EST round sorts before the EDT one
+	_, err = raw.Exec(historicalSchemas[3].schema + `;
+		INSERT INTO ping_stats (timestamp, avg) VALUES ('2025-11-02 01:50:00.123456789 -0400 EDT m=+1.000000001', 1);
+		INSERT INTO ping_stats (timestamp, avg) VALUES ('2025-11-02 01:10:00 -0500 EST', 2);
+		INSERT INTO ping_stats (timestamp, avg) VALUES ('2025-11-02 06:20:00', 3);
+		INSERT INTO pmtu_results (timestamp, target, mtu) VALUES ('2025-11-02 01:50:00 -0400 EDT', 'isp', 1500)`)
+	if err != nil {
+		t.Fatalf("Failed to create historical data: %v", err)
+	}
+	raw.Close()
+
+	db, err := initDB(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	defer db.Close()
+
+	stats, err := getRecentStats(db, "", "", 10)
+	if err != nil {
+		t.Fatalf("Failed to query migrated data: %v", err)
+	}
+	expected := []time.Time{
+		time.Date(2025, 11, 2, 5, 50, 0, 123000000, time.UTC),
+		time.Date(2025, 11, 2, 6, 10, 0, 0, time.UTC),
+		time.Date(2025, 11, 2, 6, 20, 0, 0, time.UTC), // Zoneless text is UTC
+	}
+	if len(stats) != len(expected) {
+		t.Fatalf("Expected %d rows, got %d", len(expected), len(stats))
+	}
+	for i, want := range expected {
+		if !stats[i].Timestamp.Equal(want) || *stats[i].Avg != float64(i+1) {
+			t.Errorf("Row %d: expected %v, got %v (avg %v)", i, want, stats[i].Timestamp, *stats[i].Avg)
+		}
+	}
+
+	history, err := getPMTUHistory(db, "isp", "", false, 10)
+	if err != nil {
+		t.Fatalf("Failed to query migrated PMTU results: %v", err)
+	}
+	if len(history) != 1 || !history[0].Timestamp.Equal(expected[0].Truncate(time.Second)) {
+		t.Errorf("Expected the PMTU result to be converted, got %+v", history)
+	}
+}
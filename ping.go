@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"database/sql"
 	"fmt"
 	"log"
 	"math"
@@ -12,6 +11,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -38,33 +38,236 @@ func validateTarget(target string) error {
 	return nil
 }
 
-func runPing(target string, count int) (string, error) {
-	// Validate target to prevent command injection
-	if err := validateTarget(target); err != nil {
-		return "", err
+// validateSource ensures a probe source is an IP address or an interface
+// name, so it can't be mistaken for a ping option.
+func validateSource(source string) error {
+	if ip := net.ParseIP(source); ip != nil {
+		return nil
+	}
+
+	interfaceRegex := regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.:\-]{0,31}$`)
+	if !interfaceRegex.MatchString(source) {
+		return fmt.Errorf("invalid source: not a valid IP address or interface name")
+	}
+
+	return nil
+}
+
+// pingArgs builds the ping command line for the given platform.
+// Option letters differ between iputils/BusyBox (Linux), BSD (macOS) and
+// Windows; options a platform can't express are left out, and the options
+// returned are only those actually applied.
+func pingArgs(goos, target string, count int, family string, opts ProbeOptions) (string, []string, ProbeOptions) {
+	command := "ping"
+	applied := opts
+
+	// Windows ping uses its own option letters throughout
+	if goos == "windows" {
+		args := []string{"-n", fmt.Sprint(count), "-w", "1000"}
+		switch family {
+		case FamilyIPv4:
+			args = append(args, "-4")
+		case FamilyIPv6:
+			args = append(args, "-6")
+		}
+		if opts.Size > 0 {
+			args = append(args, "-l", fmt.Sprint(opts.Size))
+		}
+		if opts.DontFragment {
+			args = append(args, "-f")
+		}
+		if opts.TTL > 0 {
+			args = append(args, "-i", fmt.Sprint(opts.TTL))
+		}
+		if net.ParseIP(opts.Source) != nil {
+			args = append(args, "-S", opts.Source)
+		} else {
+			// Windows can only bind to an address, not an interface
+			applied.Source = ""
+		}
+		// Nor can it set the TOS byte
+		applied.TOS = 0
+		return command, append(args, target), applied
 	}
 
-	// Build ping command with platform-specific timeout flags
 	args := []string{"-c", fmt.Sprint(count)}
 
+	// macOS ships a separate ping6 binary; iputils and BusyBox accept -4/-6
+	if goos == "darwin" && family == FamilyIPv6 {
+		command = "ping6"
+		if opts.Size > 0 {
+			args = append(args, "-s", fmt.Sprint(opts.Size))
+		}
+		if opts.DontFragment {
+			args = append(args, "-D")
+		}
+		if opts.TTL > 0 {
+			args = append(args, "-h", fmt.Sprint(opts.TTL))
+		}
+		if opts.Source != "" {
+			if net.ParseIP(opts.Source) != nil {
+				args = append(args, "-S", opts.Source)
+			} else {
+				args = append(args, "-I", opts.Source)
+			}
+		}
+		// ping6 has neither a timeout nor a traffic class flag
+		applied.TOS = 0
+		return command, append(args, target), applied
+	}
+
 	// Add timeout flag based on OS
 	// macOS uses -t for TTL timeout
 	// Linux uses -w for deadline (whole command timeout in seconds)
-	if runtime.GOOS == "darwin" {
+	if goos == "darwin" {
 		args = append(args, "-t", fmt.Sprint(count))
-	} else if runtime.GOOS == "linux" {
+	} else if goos == "linux" {
 		args = append(args, "-w", fmt.Sprint(count))
 	}
 	// For other platforms, no timeout flag (Windows uses -w differently)
 
-	args = append(args, target)
+	if goos != "darwin" {
+		switch family {
+		case FamilyIPv4:
+			args = append(args, "-4")
+		case FamilyIPv6:
+			args = append(args, "-6")
+		}
+	}
 
-	cmd := exec.Command("ping", args...)
+	if opts.Size > 0 {
+		args = append(args, "-s", fmt.Sprint(opts.Size))
+	}
+
+	if goos == "darwin" {
+		if opts.DontFragment {
+			args = append(args, "-D")
+		}
+		if opts.TTL > 0 {
+			args = append(args, "-m", fmt.Sprint(opts.TTL))
+		}
+		if opts.TOS > 0 {
+			args = append(args, "-z", fmt.Sprint(opts.TOS))
+		}
+		if opts.Source != "" {
+			if net.ParseIP(opts.Source) != nil {
+				args = append(args, "-S", opts.Source)
+			} else {
+				args = append(args, "-b", opts.Source)
+			}
+		}
+	} else {
+		if opts.DontFragment {
+			args = append(args, "-M", "do")
+		}
+		if opts.TTL > 0 {
+			args = append(args, "-t", fmt.Sprint(opts.TTL))
+		}
+		if opts.TOS > 0 {
+			args = append(args, "-Q", fmt.Sprint(opts.TOS))
+		}
+		if opts.Source != "" {
+			// iputils -I accepts either an address or an interface name
+			args = append(args, "-I", opts.Source)
+		}
+	}
+
+	return command, append(args, target), applied
+}
+
+// runPing returns ping's output along with the probe options it applied.
+func runPing(target string, count int, family string, opts ProbeOptions) (string, ProbeOptions, error) {
+	// Validate target to prevent command injection
+	if err := validateTarget(target); err != nil {
+		return "", ProbeOptions{}, err
+	}
+	if opts.Source != "" {
+		if err := validateSource(opts.Source); err != nil {
+			return "", ProbeOptions{}, err
+		}
+	}
+
+	command, args, applied := pingArgs(runtime.GOOS, target, count, family, opts)
+
+	cmd := exec.Command(command, args...)
 	var out bytes.Buffer
 	cmd.Stdout = &out
 	cmd.Stderr = &out
 	err := cmd.Run()
-	return out.String(), err
+	return out.String(), applied, err
+}
+
+// probeFamilies returns the address families to probe for a target's
+// family setting. "both" yields one probe per family so that IPv4 and
+// IPv6 are stored as separate series.
+func probeFamilies(family string) []string {
+	if family == FamilyBoth {
+		return []string{FamilyIPv4, FamilyIPv6}
+	}
+	return []string{family}
+}
+
+// pingNumber matches a number as printed by ping, including localized
+// decimal commas ("8,106") and "nan" for an undefined standard deviation.
+const pingNumber = `([0-9]+(?:[.,][0-9]+)?|nan)`
+
+// summaryFormat describes the round-trip summary line of one ping
+// implementation. The index fields give the submatch holding each value;
+// 0 means the implementation doesn't print it.
+type summaryFormat struct {
+	name                  string
+	re                    *regexp.Regexp
+	min, avg, max, stddev int
+}
+
+var summaryFormats = []summaryFormat{
+	{
+		// Linux iputils: rtt min/avg/max/mdev = 8.106/8.247/8.387/0.106 ms
+		name: "iputils",
+		re:   regexp.MustCompile(`rtt min/avg/max/mdev = ` + pingNumber + `/` + pingNumber + `/` + pingNumber + `/` + pingNumber + ` ms`),
+		min:  1, avg: 2, max: 3, stddev: 4,
+	},
+	{
+		// macOS and GNU inetutils: round-trip min/avg/max/stddev = 9.713/11.739/13.595/1.849 ms
+		name: "bsd",
+		re:   regexp.MustCompile(`round-trip min/avg/max/(?:stddev|std-dev|mdev) = ` + pingNumber + `/` + pingNumber + `/` + pingNumber + `/` + pingNumber + ` ms`),
+		min:  1, avg: 2, max: 3, stddev: 4,
+	},
+	{
+		// BusyBox: round-trip min/avg/max = 0.095/0.100/0.106 ms
+		name: "busybox",
+		re:   regexp.MustCompile(`round-trip min/avg/max = ` + pingNumber + `/` + pingNumber + `/` + pingNumber + ` ms`),
+		min:  1, avg: 2, max: 3,
+	},
+	{
+		// Windows: Minimum = 9ms, Maximum = 11ms, Average = 10ms
+		name: "windows",
+		re:   regexp.MustCompile(`Minimum = ` + pingNumber + `ms, Maximum = ` + pingNumber + `ms, (?:Average|Mittelwert) = ` + pingNumber + `ms`),
+		min:  1, max: 2, avg: 3,
+	},
+}
+
+// packetLossFormats match the packet loss percentage.
+var packetLossFormats = []*regexp.Regexp{
+	// Unix: "3 packets transmitted, 3 received, 0% packet loss"
+	regexp.MustCompile(pingNumber + `% packet loss`),
+	// Windows: "Lost = 0 (0% loss)"
+	regexp.MustCompile(`\(` + pingNumber + `% (?:loss|Verlust)\)`),
+}
+
+// replyTimeRe matches the round-trip time of an individual reply, used to
+// derive the standard deviation when the summary line doesn't include it.
+// The keyword is localized on Windows ("time=2ms", "Zeit=2ms", "temps=2 ms"),
+// which prints "time<1ms" for sub-millisecond replies.
+var replyTimeRe = regexp.MustCompile(`\pL+[=<]` + pingNumber + ` ?ms`)
+
+// parsePingNumber parses a number matched by pingNumber, treating "nan" as 0.0.
+func parsePingNumber(s string) float64 {
+	v, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+	if err != nil || math.IsNaN(v) {
+		return 0.0
+	}
+	return v
 }
 
 func parsePingStats(output string) (*PingStats, error) {
@@ -73,100 +276,168 @@ func parsePingStats(output string) (*PingStats, error) {
 	}
 
 	// Parse packet loss percentage
-	// Example: "3 packets transmitted, 0 packets received, 100.0% packet loss"
-	// or: "3 packets transmitted, 3 received, 0% packet loss"
-	packetLossRe := regexp.MustCompile(`([0-9.]+)% packet loss`)
-	packetLossMatches := packetLossRe.FindStringSubmatch(output)
-	if len(packetLossMatches) >= 2 {
-		packetLoss, _ := strconv.ParseFloat(packetLossMatches[1], 64)
-		stats.PacketLoss = packetLoss
-	} else {
-		// If we can't parse packet loss at all (e.g., "cannot resolve host", timeout, etc.)
-		// treat it as 100% packet loss
-		stats.PacketLoss = 100.0
-	}
-
-	// Parse the summary line from both macOS and Linux formats:
-	// macOS: round-trip min/avg/max/stddev = 9.713/11.739/13.595/1.849 ms
-	// Linux: rtt min/avg/max/mdev = 8.106/8.247/8.387/0.106 ms
-	// Note: stddev/mdev can be "nan" when only 1 packet received
-	re := regexp.MustCompile(`(?:round-trip|rtt) min/avg/max/(?:stddev|mdev) = ([0-9.]+|nan)/([0-9.]+|nan)/([0-9.]+|nan)/([0-9.]+|nan) ms`)
-	matches := re.FindStringSubmatch(output)
-
-	if len(matches) != 5 {
-		// If we can't parse ping stats (100% packet loss, timeout, or DNS failure)
-		// Leave Min, Avg, Max, StdDev as nil (NULL in database)
-		// This is valid - we have packet loss data but no latency data
+	// If we can't parse packet loss at all (e.g., "cannot resolve host", timeout, etc.)
+	// treat it as 100% packet loss
+	stats.PacketLoss = 100.0
+	for _, re := range packetLossFormats {
+		if matches := re.FindStringSubmatch(output); matches != nil {
+			stats.PacketLoss = parsePingNumber(matches[1])
+			break
+		}
+	}
+
+	// Find the summary line of whichever implementation produced the output
+	for _, format := range summaryFormats {
+		matches := format.re.FindStringSubmatch(output)
+		if matches == nil {
+			continue
+		}
+
+		min := parsePingNumber(matches[format.min])
+		avg := parsePingNumber(matches[format.avg])
+		max := parsePingNumber(matches[format.max])
+		stats.Min = &min
+		stats.Avg = &avg
+		stats.Max = &max
+		if format.stddev > 0 {
+			stddev := parsePingNumber(matches[format.stddev])
+			stats.StdDev = &stddev
+		} else if stddev, ok := replyStdDev(output); ok {
+			stats.StdDev = &stddev
+		}
 		return stats, nil
 	}
 
-	// Parse values, treating "nan" as 0.0
-	min, _ := strconv.ParseFloat(matches[1], 64)
-	if math.IsNaN(min) {
-		min = 0.0
+	// If we can't parse ping stats (100% packet loss, timeout, or DNS failure)
+	// Leave Min, Avg, Max, StdDev as nil (NULL in database)
+	// This is valid - we have packet loss data but no latency data
+	return stats, nil
+}
+
+// replyStdDev computes the population standard deviation of the individual
+// reply times in the output, matching what iputils reports as mdev. It
+// reports false when no reply times were found.
+func replyStdDev(output string) (float64, bool) {
+	matches := replyTimeRe.FindAllStringSubmatch(output, -1)
+	if len(matches) == 0 {
+		return 0.0, false
 	}
-	avg, _ := strconv.ParseFloat(matches[2], 64)
-	if math.IsNaN(avg) {
-		avg = 0.0
+
+	var sum, sumSquares float64
+	for _, m := range matches {
+		v := parsePingNumber(m[1])
+		sum += v
+		sumSquares += v * v
 	}
-	max, _ := strconv.ParseFloat(matches[3], 64)
-	if math.IsNaN(max) {
-		max = 0.0
+	n := float64(len(matches))
+	mean := sum / n
+	return math.Sqrt(math.Max(sumSquares/n-mean*mean, 0)), true
+}
+
+func runPingMonitor(writer *statsWriter, health *monitorHealth, alerts *alertManager, heartbeat *heartbeat, anomalies *anomalyDetector, targets []TargetConfig, pingCount int) {
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target TargetConfig) {
+			defer wg.Done()
+			monitorTarget(writer, health, alerts, heartbeat, anomalies, target, pingCount)
+		}(target)
 	}
-	stddev, _ := strconv.ParseFloat(matches[4], 64)
-	if math.IsNaN(stddev) {
-		stddev = 0.0
+	wg.Wait()
+}
+
+func monitorTarget(writer *statsWriter, health *monitorHealth, alerts *alertManager, heartbeat *heartbeat, anomalies *anomalyDetector, target TargetConfig, pingCount int) {
+	families := probeFamilies(target.Family)
+	log.Printf("Starting continuous ping monitoring to %s (%s) with %d pings per round",
+		target.Name, target.Host, pingCount)
+	for _, family := range families {
+		if _, _, applied := pingArgs(runtime.GOOS, target.Host, pingCount, family, target.ProbeOptions); applied != target.ProbeOptions {
+			log.Printf("Warning: ping on %s can't apply every probe option for %s; rounds record only those applied (%+v)",
+				runtime.GOOS, seriesLabel(target.Name, family), applied)
+		}
 	}
 
-	stats.Min = &min
-	stats.Avg = &avg
-	stats.Max = &max
-	stats.StdDev = &stddev
+	for {
+		log.Printf("Running ping round for %s...", target.Name)
 
-	return stats, nil
+		// Probe every family in parallel so IPv4 and IPv6 rounds line up in time
+		var wg sync.WaitGroup
+		for _, family := range families {
+			wg.Add(1)
+			go func(family string) {
+				defer wg.Done()
+				runPingRound(writer, health, alerts, heartbeat, anomalies, target, family, pingCount)
+			}(family)
+		}
+		wg.Wait()
+
+		// Wait before next ping round to avoid hammering the target
+		time.Sleep(roundPause)
+	}
 }
 
-func runPingMonitor(db *sql.DB, target string, pingCount int, retentionDays int) {
-	log.Printf("Starting continuous ping monitoring to %s with %d pings per round", target, pingCount)
+func runPingRound(writer *statsWriter, health *monitorHealth, alerts *alertManager, heartbeat *heartbeat, anomalies *anomalyDetector, target TargetConfig, family string, pingCount int) {
+	label := seriesLabel(target.Name, family)
+	output, applied, cmdErr := runPing(target.Host, pingCount, family, target.ProbeOptions)
 
-	for {
-		log.Printf("Running ping round...")
-		output, cmdErr := runPing(target, pingCount)
-
-		// Try to parse stats even if ping command failed
-		// (output may still contain packet loss information)
-		stats, err := parsePingStats(output)
-		if err != nil {
-			log.Printf("Failed to parse ping stats: %v (output: %s)", err, output)
-			time.Sleep(5 * time.Second)
-			continue
-		}
+	// Try to parse stats even if ping command failed
+	// (output may still contain packet loss information)
+	stats, err := parsePingStats(output)
+	if err != nil {
+		log.Printf("[%s] Failed to parse ping stats: %v (output: %s)", label, err, output)
+		health.recordError(label, err, time.Now())
+		heartbeat.observe(target.Name, family, nil, time.Now())
+		return
+	}
+	stats.Target = target.Name
+	stats.Family = family
+	stats.Probe = applied
 
-		// Log the command error if there was one, but still save the stats
-		if cmdErr != nil {
-			log.Printf("Ping command error: %v (packet loss: %.1f%%)", cmdErr, stats.PacketLoss)
-		}
+	// Log the command error if there was one, but still save the stats
+	if cmdErr != nil {
+		log.Printf("[%s] Ping command error: %v (packet loss: %.1f%%)", label, cmdErr, stats.PacketLoss)
+		health.recordError(label, cmdErr, time.Now())
+	}
 
-		err = savePingStats(db, stats, retentionDays)
-		if err != nil {
-			log.Printf("Failed to save stats: %v", err)
-			time.Sleep(5 * time.Second)
-			continue
-		}
+	if anomalies.score(stats) {
+		log.Printf("[%s] Anomalous round: avg %.3f ms is %.1f deviations above baseline", label, *stats.Avg, *stats.AnomalyScore)
+	}
 
-		if stats.PacketLoss > 0 {
-			if stats.Min != nil {
-				log.Printf("Saved stats: min=%.3f avg=%.3f max=%.3f stddev=%.3f ms (packet loss: %.1f%%)",
-					*stats.Min, *stats.Avg, *stats.Max, *stats.StdDev, stats.PacketLoss)
-			} else {
-				log.Printf("Saved stats: no data available (packet loss: %.1f%%)", stats.PacketLoss)
-			}
-		} else {
-			log.Printf("Saved stats: min=%.3f avg=%.3f max=%.3f stddev=%.3f ms",
-				*stats.Min, *stats.Avg, *stats.Max, *stats.StdDev)
-		}
+	err = writer.Save(stats)
+	if err != nil {
+		log.Printf("[%s] Failed to save stats: %v", label, err)
+		health.recordError(label, err, time.Now())
+		return
+	}
+	health.roundCompleted(label, time.Now())
+	notifyRoundStatus(label, stats)
+	alerts.observe(stats)
+	heartbeat.observe(target.Name, family, stats, time.Now())
 
-		// Wait before next ping round to avoid hammering the target
-		time.Sleep(5 * time.Second)
+	log.Printf("[%s] Saved stats: %s", label, savedStatsMessage(stats))
+}
+
+// savedStatsMessage describes a saved round for the log. StdDev is missing
+// when the output had no individual reply times.
+func savedStatsMessage(stats *PingStats) string {
+	if stats.Min == nil {
+		return fmt.Sprintf("no data available (packet loss: %.1f%%)", stats.PacketLoss)
+	}
+	msg := fmt.Sprintf("min=%.3f avg=%.3f max=%.3f", *stats.Min, *stats.Avg, *stats.Max)
+	if stats.StdDev != nil {
+		msg += fmt.Sprintf(" stddev=%.3f", *stats.StdDev)
+	}
+	msg += " ms"
+	if stats.PacketLoss > 0 {
+		msg += fmt.Sprintf(" (packet loss: %.1f%%)", stats.PacketLoss)
+	}
+	return msg
+}
+
+// seriesLabel formats a target and family for log messages, e.g. "google/IPv6".
+func seriesLabel(target, family string) string {
+	if family == FamilyAny {
+		return target
 	}
+	return target + "/IPv" + family
 }
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -178,3 +182,227 @@ round-trip min/avg/max/stddev = 105.910/105.910/105.910/nan ms`
 		t.Errorf("Expected stddev 0.0 (from nan), got %f", *stats.StdDev)
 	}
 }
+
+func TestProbeFamilies(t *testing.T) {
+	tests := []struct {
+		family string
+		want   []string
+	}{
+		{FamilyAny, []string{FamilyAny}},
+		{FamilyIPv4, []string{FamilyIPv4}},
+		{FamilyIPv6, []string{FamilyIPv6}},
+		{FamilyBoth, []string{FamilyIPv4, FamilyIPv6}},
+	}
+
+	for _, tt := range tests {
+		got := probeFamilies(tt.family)
+		if len(got) != len(tt.want) {
+			t.Errorf("probeFamilies(%q) = %v, want %v", tt.family, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("probeFamilies(%q) = %v, want %v", tt.family, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestPingArgs(t *testing.T) {
+	opts := ProbeOptions{Size: 1472, DontFragment: true, TTL: 32, TOS: 184, Source: "eth0"}
+
+	tests := []struct {
+		name    string
+		goos    string
+		family  string
+		opts    ProbeOptions
+		command string
+		args    []string
+		applied ProbeOptions
+	}{
+		{
+			name:    "linux defaults",
+			goos:    "linux",
+			command: "ping",
+			args:    []string{"-c", "5", "-w", "5", "8.8.8.8"},
+		},
+		{
+			name:    "linux all options",
+			goos:    "linux",
+			family:  FamilyIPv4,
+			opts:    opts,
+			command: "ping",
+			args:    []string{"-c", "5", "-w", "5", "-4", "-s", "1472", "-M", "do", "-t", "32", "-Q", "184", "-I", "eth0", "8.8.8.8"},
+			applied: opts,
+		},
+		{
+			name:    "darwin all options",
+			goos:    "darwin",
+			family:  FamilyIPv4,
+			opts:    ProbeOptions{Size: 1472, DontFragment: true, TTL: 32, TOS: 184, Source: "192.168.1.2"},
+			command: "ping",
+			args:    []string{"-c", "5", "-t", "5", "-s", "1472", "-D", "-m", "32", "-z", "184", "-S", "192.168.1.2", "8.8.8.8"},
+			applied: ProbeOptions{Size: 1472, DontFragment: true, TTL: 32, TOS: 184, Source: "192.168.1.2"},
+		},
+		{
+			name:    "windows all options",
+			goos:    "windows",
+			family:  FamilyIPv6,
+			opts:    ProbeOptions{Size: 1472, DontFragment: true, TTL: 32, Source: "fe80::1"},
+			command: "ping",
+			args:    []string{"-n", "5", "-w", "1000", "-6", "-l", "1472", "-f", "-i", "32", "-S", "fe80::1", "8.8.8.8"},
+			applied: ProbeOptions{Size: 1472, DontFragment: true, TTL: 32, Source: "fe80::1"},
+		},
+		{
+			// No TOS, and no binding to an interface
+			name:    "windows unsupported options",
+			goos:    "windows",
+			family:  FamilyIPv4,
+			opts:    opts,
+			command: "ping",
+			args:    []string{"-n", "5", "-w", "1000", "-4", "-l", "1472", "-f", "-i", "32", "8.8.8.8"},
+			applied: ProbeOptions{Size: 1472, DontFragment: true, TTL: 32},
+		},
+		{
+			name:    "darwin ipv6",
+			goos:    "darwin",
+			family:  FamilyIPv6,
+			opts:    opts,
+			command: "ping6",
+			args:    []string{"-c", "5", "-s", "1472", "-D", "-h", "32", "-I", "eth0", "8.8.8.8"},
+			applied: ProbeOptions{Size: 1472, DontFragment: true, TTL: 32, Source: "eth0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command, args, applied := pingArgs(tt.goos, "8.8.8.8", 5, tt.family, tt.opts)
+			if command != tt.command {
+				t.Errorf("Expected command %s, got %s", tt.command, command)
+			}
+			if strings.Join(args, " ") != strings.Join(tt.args, " ") {
+				t.Errorf("Expected args %v, got %v", tt.args, args)
+			}
+			if applied != tt.applied {
+				t.Errorf("Expected the applied options %+v, got %+v", tt.applied, applied)
+			}
+		})
+	}
+}
+
+func TestValidateSource(t *testing.T) {
+	for _, source := range []string{"eth0", "wlan0.100", "192.168.1.2", "fe80::1"} {
+		if err := validateSource(source); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", source, err)
+		}
+	}
+	for _, source := range []string{"-f", "eth0;reboot", ""} {
+		if err := validateSource(source); err == nil {
+			t.Errorf("Expected %q to be rejected", source)
+		}
+	}
+}
+
+func TestParsePingStatsWithoutReplyTimes(t *testing.T) {
+	// Only the summary, e.g. when the reply lines were filtered out
+	output := `Ping statistics for 8.8.8.8:
+    Packets: Sent = 4, Received = 4, Lost = 0 (0% loss),
+Approximate round trip times in milli-seconds:
+    Minimum = 9ms, Maximum = 11ms, Average = 10ms`
+
+	stats, err := parsePingStats(output)
+	if err != nil {
+		t.Fatalf("Failed to parse ping output: %v", err)
+	}
+	if stats.Avg == nil || *stats.Avg != 10 {
+		t.Errorf("Expected avg 10, got %v", stats.Avg)
+	}
+	if stats.StdDev != nil {
+		t.Errorf("Expected no stddev without reply times, got %v", *stats.StdDev)
+	}
+	if msg := savedStatsMessage(stats); msg != "min=9.000 avg=10.000 max=11.000 ms" {
+		t.Errorf("Expected the log line to leave out stddev, got %q", msg)
+	}
+}
+
+func TestSavedStatsMessage(t *testing.T) {
+	tests := []struct {
+		stats PingStats
+		want  string
+	}{
+		{PingStats{Min: float64Ptr(9), Avg: float64Ptr(10), Max: float64Ptr(11), StdDev: float64Ptr(0.5)},
+			"min=9.000 avg=10.000 max=11.000 stddev=0.500 ms"},
+		{PingStats{Min: float64Ptr(9), Avg: float64Ptr(10), Max: float64Ptr(11), PacketLoss: 20},
+			"min=9.000 avg=10.000 max=11.000 ms (packet loss: 20.0%)"},
+		{PingStats{PacketLoss: 100}, "no data available (packet loss: 100.0%)"},
+	}
+	for _, tt := range tests {
+		if got := savedStatsMessage(&tt.stats); got != tt.want {
+			t.Errorf("Expected %q, got %q", tt.want, got)
+		}
+	}
+}
+
+func TestParsePingStatsFixtures(t *testing.T) {
+	tests := []struct {
+		fixture    string
+		packetLoss float64
+		min        *float64 // nil when no latency data is expected
+		avg        float64
+		max        float64
+		stddev     float64
+	}{
+		{"iputils.txt", 0, float64Ptr(8.106), 8.247, 8.387, 0.106},
+		{"iputils-loss.txt", 20, float64Ptr(12.412), 12.751, 13.104, 0.267},
+		{"iputils-decimal-comma.txt", 0, float64Ptr(14.215), 14.612, 15.004, 0.322},
+		{"macos.txt", 0, float64Ptr(9.789), 10.493, 11.456, 0.693},
+		{"busybox.txt", 0, float64Ptr(0.4), 0.5, 0.6, 0.0816},
+		{"busybox-timeout.txt", 100, nil, 0, 0, 0},
+		{"inetutils.txt", 0, float64Ptr(9.101), 9.409, 9.815, 0.299},
+		{"windows.txt", 25, float64Ptr(9), 10, 11, 0.8165},
+		{"windows-de.txt", 0, float64Ptr(0), 1, 2, 0.4330}, // Replies of 1 (<1), 2, 1 (<1) and 1 ms
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			output, err := os.ReadFile(filepath.Join("testdata", "ping", tt.fixture))
+			if err != nil {
+				t.Fatalf("Failed to read fixture: %v", err)
+			}
+
+			stats, err := parsePingStats(string(output))
+			if err != nil {
+				t.Fatalf("Failed to parse ping output: %v", err)
+			}
+
+			if stats.PacketLoss != tt.packetLoss {
+				t.Errorf("Expected packet loss %v, got %v", tt.packetLoss, stats.PacketLoss)
+			}
+			if tt.min == nil {
+				if stats.Min != nil || stats.Avg != nil || stats.Max != nil || stats.StdDev != nil {
+					t.Errorf("Expected nil stats, got min=%v avg=%v max=%v stddev=%v",
+						stats.Min, stats.Avg, stats.Max, stats.StdDev)
+				}
+				return
+			}
+
+			if stats.Min == nil || stats.Avg == nil || stats.Max == nil || stats.StdDev == nil {
+				t.Fatalf("Expected latency data, got min=%v avg=%v max=%v stddev=%v",
+					stats.Min, stats.Avg, stats.Max, stats.StdDev)
+			}
+			if *stats.Min != *tt.min {
+				t.Errorf("Expected min %v, got %v", *tt.min, *stats.Min)
+			}
+			if *stats.Avg != tt.avg {
+				t.Errorf("Expected avg %v, got %v", tt.avg, *stats.Avg)
+			}
+			if *stats.Max != tt.max {
+				t.Errorf("Expected max %v, got %v", tt.max, *stats.Max)
+			}
+			if math.Abs(*stats.StdDev-tt.stddev) > 0.0001 {
+				t.Errorf("Expected stddev %v, got %v", tt.stddev, *stats.StdDev)
+			}
+		})
+	}
+}
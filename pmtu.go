@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// minPMTU is the smallest MTU an IPv4 link may have (RFC 791)
+	minPMTU = 68
+	// defaultPMTUMax is the largest MTU tried when pmtu_max is unset
+	defaultPMTUMax = 1500
+)
+
+// headerOverhead returns the IP + ICMP header bytes added to a ping
+// payload, so that MTU = payload + overhead.
+func headerOverhead(family string) int {
+	if family == FamilyIPv6 {
+		return 40 + 8
+	}
+	return 20 + 8
+}
+
+// searchPMTU binary-searches the largest payload in [lo, hi] for which
+// fits returns true. It assumes fits is monotonic and returns -1 when even
+// lo doesn't fit.
+func searchPMTU(lo, hi int, fits func(size int) bool) int {
+	if !fits(lo) {
+		return -1
+	}
+	if fits(hi) {
+		return hi
+	}
+
+	// Invariant: lo fits, hi doesn't
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		if fits(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// discoverPMTU finds the path MTU to host by sending pings with the
+// don't-fragment bit set and binary-searching the payload size.
+func discoverPMTU(host, family string, opts ProbeOptions, maxMTU int) (int, error) {
+	if maxMTU == 0 {
+		maxMTU = defaultPMTUMax
+	}
+	overhead := headerOverhead(family)
+
+	opts.DontFragment = true
+	fits := func(size int) bool {
+		opts.Size = size
+		// Two packets so a single random drop doesn't shrink the result
+		output, _, _ := runPing(host, 2, family, opts)
+		stats, err := parsePingStats(output)
+		return err == nil && stats.PacketLoss < 100
+	}
+
+	payload := searchPMTU(minPMTU-overhead, maxMTU-overhead, fits)
+	if payload < 0 {
+		return 0, fmt.Errorf("no reply from %s even at the minimum MTU", host)
+	}
+	return payload + overhead, nil
+}
+
+func runPMTUMonitor(db *DB, targets []TargetConfig) {
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		if target.PMTUInterval <= 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(target TargetConfig) {
+			defer wg.Done()
+			monitorPMTU(db, target)
+		}(target)
+	}
+	wg.Wait()
+}
+
+func monitorPMTU(db *DB, target TargetConfig) {
+	log.Printf("Starting path MTU discovery to %s every %s", target.Name, target.PMTUInterval)
+
+	for {
+		for _, family := range probeFamilies(target.Family) {
+			label := seriesLabel(target.Name, family)
+
+			mtu, err := discoverPMTU(target.Host, family, target.ProbeOptions, target.PMTUMax)
+			if err != nil {
+				log.Printf("[%s] Path MTU discovery failed: %v", label, err)
+				continue
+			}
+
+			result, err := recordPMTU(db, target.Name, family, mtu, time.Now())
+			if err != nil {
+				log.Printf("[%s] Failed to save path MTU: %v", label, err)
+				continue
+			}
+
+			if result.Changed {
+				log.Printf("[%s] Path MTU changed from %d to %d", label, result.PreviousMTU, result.MTU)
+			} else {
+				log.Printf("[%s] Path MTU: %d", label, result.MTU)
+			}
+		}
+
+		time.Sleep(target.PMTUInterval)
+	}
+}
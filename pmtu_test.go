@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSearchPMTU(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int // largest payload that gets through, -1 for none
+		want  int
+	}{
+		{"ethernet", 1472, 1472},
+		{"pppoe", 1464, 1464},
+		{"jumbo capped at max", 9000, 1472},
+		{"tiny", 40, 40},
+		{"unreachable", -1, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probes := 0
+			fits := func(size int) bool {
+				probes++
+				return size <= tt.limit
+			}
+
+			got := searchPMTU(40, 1472, fits)
+			if got != tt.want {
+				t.Errorf("Expected payload %d, got %d", tt.want, got)
+			}
+			if probes > 13 {
+				t.Errorf("Expected a binary search (at most 13 probes), got %d probes", probes)
+			}
+		})
+	}
+}
+
+func TestHeaderOverhead(t *testing.T) {
+	if got := headerOverhead(FamilyIPv4); got != 28 {
+		t.Errorf("Expected IPv4 overhead 28, got %d", got)
+	}
+	if got := headerOverhead(FamilyAny); got != 28 {
+		t.Errorf("Expected default overhead 28, got %d", got)
+	}
+	if got := headerOverhead(FamilyIPv6); got != 48 {
+		t.Errorf("Expected IPv6 overhead 48, got %d", got)
+	}
+}
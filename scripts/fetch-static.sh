@@ -0,0 +1,47 @@
+#!/bin/sh
+# Download the dashboard's third-party assets into static/, where they are
+# committed and embedded in the binary.
+#
+#   fetch-static.sh           download the pinned versions, verified against static/SHA256SUMS
+#   fetch-static.sh --update  download after a version bump and record the new checksums
+#   fetch-static.sh --check   verify the committed copies without downloading
+set -e
+
+PICO_VERSION=2.1.1
+CHARTS_VERSION=5.0.0
+
+static="$(cd "$(dirname "$0")/../static" && pwd)"
+
+sha256() {
+	if command -v sha256sum >/dev/null; then
+		sha256sum "$@"
+	else
+		shasum -a 256 "$@"
+	fi
+}
+
+if [ "$1" = "--check" ]; then
+	cd "$static"
+	sha256 -c SHA256SUMS
+	exit
+fi
+
+tmp="$(mktemp -d)"
+trap 'rm -rf "$tmp"' EXIT
+cd "$tmp"
+
+fetch() {
+	echo "Fetching $2"
+	curl -fsSL -o "$1" "$2"
+}
+
+fetch pico.min.css "https://cdn.jsdelivr.net/npm/@picocss/pico@$PICO_VERSION/css/pico.min.css"
+fetch lightweight-charts.standalone.production.js "https://cdn.jsdelivr.net/npm/lightweight-charts@$CHARTS_VERSION/dist/lightweight-charts.standalone.production.js"
+
+if [ "$1" = "--update" ]; then
+	sha256 pico.min.css lightweight-charts.standalone.production.js > "$static/SHA256SUMS"
+	echo "Recorded new checksums in static/SHA256SUMS"
+else
+	sha256 -c "$static/SHA256SUMS"
+fi
+mv pico.min.css lightweight-charts.standalone.production.js "$static/"
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sdNotify sends a state update such as "READY=1" to systemd. It does
+// nothing unless pingo runs as a Type=notify service.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// A leading @ names a socket in the abstract namespace
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns how often to ping systemd's watchdog: half of
+// WatchdogSec, or 0 when the watchdog isn't enabled for this process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// runWatchdog pings systemd's watchdog for as long as every series keeps
+// saving rounds. Once the monitor gets stuck the pings stop and systemd
+// restarts the service.
+func runWatchdog(health *monitorHealth, series []SeriesInfo, maxAge, interval time.Duration) {
+	log.Printf("Pinging the systemd watchdog every %s", interval)
+	for {
+		time.Sleep(interval)
+		if !health.report(series, maxAge, nil, time.Now()).Ready {
+			continue
+		}
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			log.Printf("Failed to ping the systemd watchdog: %v", err)
+		}
+	}
+}
+
+// notifyRoundStatus shows a series' latest round in "systemctl status".
+func notifyRoundStatus(label string, stats *PingStats) {
+	status := fmt.Sprintf("STATUS=%s: loss %.1f%%", label, stats.PacketLoss)
+	if stats.Avg != nil {
+		status += fmt.Sprintf(", avg %.3f ms", *stats.Avg)
+	}
+	if err := sdNotify(status); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+}
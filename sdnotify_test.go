@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", socket)
+	notifyRoundStatus("google/IPv4", &PingStats{PacketLoss: 20, Avg: float64Ptr(12.5)})
+
+	buf := make([]byte, 256)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to read notification: %v", err)
+	}
+	if got, want := string(buf[:n]), "STATUS=google/IPv4: loss 20.0%, avg 12.500 ms"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	// Without a socket, notifications are dropped
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("Expected no error without NOTIFY_SOCKET, got %v", err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{"", "", 0},
+		{"60000000", "", 30 * time.Second},
+		{"60000000", strconv.Itoa(os.Getpid()), 30 * time.Second},
+		{"60000000", "1", 0}, // Meant for another process
+		{"invalid", "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+		if got := watchdogInterval(); got != tt.want {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: expected %s, got %s", tt.usec, tt.pid, tt.want, got)
+		}
+	}
+}
@@ -1,19 +1,63 @@
 package main
 
 import (
-	"database/sql"
 	"embed"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
+	"io/fs"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"time"
 )
 
 //go:embed templates/*
 var templatesFS embed.FS
 
-func startWebServer(db *sql.DB, port string) {
-	tmpl := template.Must(template.ParseFS(templatesFS, "templates/index.html"))
+// SeriesInfo describes one chartable series: a target probed over a
+// single address family.
+type SeriesInfo struct {
+	Target string `json:"target"`
+	Host   string `json:"host"`
+	Family string `json:"family"`
+	Label  string `json:"label"`
+}
+
+func listSeries(targets []TargetConfig) []SeriesInfo {
+	var series []SeriesInfo
+	for _, t := range targets {
+		for _, family := range probeFamilies(t.Family) {
+			series = append(series, SeriesInfo{
+				Target: t.Name,
+				Host:   t.Host,
+				Family: family,
+				Label:  seriesLabel(t.Name, family),
+			})
+		}
+	}
+	return series
+}
+
+func startWebServer(db *DB, store Store, config Config, targets []TargetConfig, bufferbloat *bufferbloatRunner, health *monitorHealth, silences *silencer, anomalies *anomalyDetector) {
+	staticDir, err := fs.Sub(staticFS, "static")
+	if err != nil {
+		log.Fatalf("Failed to load static assets: %v", err)
+	}
+	assets, err := loadStaticAssets(staticDir)
+	if err != nil {
+		log.Fatalf("Failed to load static assets: %v", err)
+	}
+
+	tmpl := template.Must(template.New("index.html").
+		Funcs(template.FuncMap{"asset": assets.url}).
+		ParseFS(templatesFS, "templates/index.html"))
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html")
@@ -23,23 +67,37 @@ func startWebServer(db *sql.DB, port string) {
 		}
 	})
 
+	http.Handle("/static/", assets)
+
 	http.HandleFunc("/api/stats", func(w http.ResponseWriter, r *http.Request) {
 		startDate := r.URL.Query().Get("start")
 		endDate := r.URL.Query().Get("end")
 		since := r.URL.Query().Get("since")
+		target := r.URL.Query().Get("target")
+		family := r.URL.Query().Get("family")
+
+		for _, value := range []string{startDate, endDate, since} {
+			if value == "" {
+				continue
+			}
+			if _, err := parseTimestamp(value); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 
 		var stats []PingStats
 		var err error
 
 		if startDate != "" && endDate != "" {
 			// Filtered date range
-			stats, err = getStatsByDateRange(db, startDate, endDate)
+			stats, err = getStatsByDateRange(store, target, family, startDate, endDate)
 		} else if since != "" {
 			// Get data since a specific timestamp (for polling)
-			stats, err = getStatsSince(db, since)
+			stats, err = getStatsSince(store, target, family, since)
 		} else {
 			// Initial load - get all data (or recent data with high limit)
-			stats, err = getRecentStats(db, 1000)
+			stats, err = getRecentStats(store, target, family, 1000)
 		}
 
 		if err != nil {
@@ -50,8 +108,383 @@ func startWebServer(db *sql.DB, port string) {
 		json.NewEncoder(w).Encode(stats)
 	})
 
-	log.Printf("Web server starting on http://localhost:%s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	http.HandleFunc("/api/targets", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(listSeries(targets))
+	})
+
+	http.HandleFunc("/api/pmtu", func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		family := r.URL.Query().Get("family")
+		changesOnly := r.URL.Query().Get("changes") == "true"
+
+		results, err := getPMTUHistory(db, target, family, changesOnly, 1000)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	})
+
+	http.HandleFunc("/api/bufferbloat", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			results, err := getBufferbloatHistory(db, r.URL.Query().Get("target"), 1000)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(results)
+
+		case http.MethodPost:
+			// Run a test on demand; this blocks for the length of the test
+			if bufferbloat == nil {
+				http.Error(w, "bufferbloat test is not configured", http.StatusNotFound)
+				return
+			}
+			if status, err := checkWriteRequest(r); err != nil {
+				http.Error(w, err.Error(), status)
+				return
+			}
+			result, err := bufferbloat.Run()
+			if err == errBufferbloatRunning {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(result)
+
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/api/storage", func(w http.ResponseWriter, r *http.Request) {
+		report, err := getStorageReport(db, config.maxDBBytes(), config.RetentionDays)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	})
+
+	http.HandleFunc("/api/daily", func(w http.ResponseWriter, r *http.Request) {
+		// Default to the last year, up to today
+		end := time.Now().Format(dayLayout)
+		start := time.Now().AddDate(-1, 0, 0).Format(dayLayout)
+		for param, day := range map[string]*string{"start": &start, "end": &end} {
+			value := r.URL.Query().Get(param)
+			if value == "" {
+				continue
+			}
+			if _, err := time.Parse(dayLayout, value); err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s day %q: expected YYYY-MM-DD", param, value), http.StatusBadRequest)
+				return
+			}
+			*day = value
+		}
+
+		summaries, err := store.DailySummaries(r.URL.Query().Get("target"), r.URL.Query().Get("family"), start, end)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(summaries)
+	})
+
+	var slaExclusions *silencer
+	if config.SLAExcludeMaintenance {
+		slaExclusions = silences
+	}
+	http.Handle("/api/v1/", newAPIv1(store, targets, slaExclusions))
+
+	// Liveness only needs the process to answer; readiness also needs the
+	// database to take writes and every series to be saving rounds
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		report := health.report(listSeries(targets), config.readyMaxAge(), checkWritable(db), time.Now())
+		status := http.StatusOK
+		if !report.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, report)
+	})
+
+	http.HandleFunc("/api/annotations", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			// Default to everything
+			start, end := time.Unix(0, 0), time.Now().AddDate(100, 0, 0)
+			for param, t := range map[string]*time.Time{"start": &start, "end": &end} {
+				value := r.URL.Query().Get(param)
+				if value == "" {
+					continue
+				}
+				parsed, err := parseTimestamp(value)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("invalid %s: %v", param, err), http.StatusBadRequest)
+					return
+				}
+				*t = parsed
+			}
+
+			annotations, err := listAnnotations(db, start, end, r.URL.Query().Get("tag"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(annotations)
+
+		case http.MethodPost:
+			if status, err := checkWriteRequest(r); err != nil {
+				http.Error(w, err.Error(), status)
+				return
+			}
+			var a Annotation
+			if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+				http.Error(w, fmt.Sprintf("invalid annotation: %v", err), http.StatusBadRequest)
+				return
+			}
+			if a.Time.IsZero() {
+				a.Time = time.Now()
+			}
+			if err := a.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := createAnnotation(db, &a); err != nil {
+				writeAnnotationError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(a)
+
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/api/annotations/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid annotation id", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			a, err := getAnnotation(db, id)
+			if err != nil {
+				writeAnnotationError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(a)
+
+		case http.MethodPut:
+			if status, err := checkWriteRequest(r); err != nil {
+				http.Error(w, err.Error(), status)
+				return
+			}
+			var a Annotation
+			if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+				http.Error(w, fmt.Sprintf("invalid annotation: %v", err), http.StatusBadRequest)
+				return
+			}
+			a.ID = id
+			if err := a.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := updateAnnotation(db, &a); err != nil {
+				writeAnnotationError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(a)
+
+		case http.MethodDelete:
+			if err := deleteAnnotation(db, id); err != nil {
+				writeAnnotationError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/api/anomalies", func(w http.ResponseWriter, r *http.Request) {
+		// Default to the last day
+		end := time.Now()
+		start := end.Add(-24 * time.Hour)
+		for param, t := range map[string]*time.Time{"start": &start, "end": &end} {
+			value := r.URL.Query().Get(param)
+			if value == "" {
+				continue
+			}
+			parsed, err := parseTimestamp(value)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s: %v", param, err), http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+
+		response := anomaliesResponse{Anomalies: []PingStats{}}
+		if anomalies != nil {
+			rounds, err := store.StatsBetween(r.URL.Query().Get("target"), r.URL.Query().Get("family"), start, end)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			response.Deviations = anomalies.config.Deviations
+			response.Anomalies = flaggedRounds(rounds, response.Deviations)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+
+	http.HandleFunc("/api/silences", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			// Active and upcoming silences
+			now := time.Now()
+			silenceList, err := listSilences(db, now, now.AddDate(100, 0, 0))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(silenceList)
+
+		case http.MethodPost:
+			if status, err := checkWriteRequest(r); err != nil {
+				http.Error(w, err.Error(), status)
+				return
+			}
+			var silence Silence
+			if err := json.NewDecoder(r.Body).Decode(&silence); err != nil {
+				http.Error(w, fmt.Sprintf("invalid silence: %v", err), http.StatusBadRequest)
+				return
+			}
+			if silence.Starts.IsZero() {
+				silence.Starts = time.Now()
+			}
+			if err := silence.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if _, ok := findTarget(targets, silence.Target); silence.Target != "" && !ok {
+				http.Error(w, fmt.Sprintf("unknown target %q", silence.Target), http.StatusBadRequest)
+				return
+			}
+			if silence.Rule != "" && !slices.ContainsFunc(config.Alerts, func(a AlertRule) bool { return a.Name == silence.Rule }) {
+				http.Error(w, fmt.Sprintf("unknown alert %q", silence.Rule), http.StatusBadRequest)
+				return
+			}
+			if err := createSilence(db, &silence); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(silence)
+
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/api/silences/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid silence id", http.StatusBadRequest)
+			return
+		}
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		err = deleteSilence(db, id)
+		if errors.Is(err, errSilenceNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	listeners, err := openListeners(config.listenAddresses(), os.FileMode(config.SocketMode))
+	if err != nil {
 		log.Fatalf("Failed to start web server: %v", err)
 	}
+
+	// The database is open and requests are accepted from here on
+	if err := sdNotify("READY=1"); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		log.Printf("Web server listening on %s", describeListener(listener))
+		go func(listener net.Listener) {
+			errs <- http.Serve(listener, nil)
+		}(listener)
+	}
+	log.Fatalf("Web server failed: %v", <-errs)
+}
+
+// describeListener formats a listener's address for the log, as a URL for
+// TCP listeners.
+func describeListener(listener net.Listener) string {
+	addr := listener.Addr()
+	if addr.Network() == "unix" {
+		return unixPrefix + addr.String()
+	}
+	return "http://" + addr.String()
+}
+
+// writeAnnotationError reports an unknown annotation ID as a 404.
+func writeAnnotationError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errAnnotationNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// checkWriteRequest guards endpoints that change state against cross-site
+// requests from any web page open on the LAN. A JSON content type can't be
+// sent cross-origin without a CORS preflight, which pingo never approves,
+// and a browser's Origin must be pingo itself.
+func checkWriteRequest(r *http.Request) (int, error) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		return http.StatusUnsupportedMediaType, fmt.Errorf("Content-Type must be application/json")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		parsed, err := url.Parse(origin)
+		if err != nil || parsed.Host != r.Host {
+			return http.StatusForbidden, fmt.Errorf("cross-origin requests are not allowed")
+		}
+	}
+	return http.StatusOK, nil
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckWriteRequest(t *testing.T) {
+	tests := []struct {
+		contentType, origin string
+		status              int
+	}{
+		{"application/json", "", http.StatusOK},
+		{"application/json; charset=utf-8", "http://pingo.lan:7777", http.StatusOK},
+		// What a form or fetch from another site can send without a preflight
+		{"", "", http.StatusUnsupportedMediaType},
+		{"text/plain", "http://evil.example", http.StatusUnsupportedMediaType},
+		{"application/x-www-form-urlencoded", "", http.StatusUnsupportedMediaType},
+		{"application/json", "http://evil.example", http.StatusForbidden},
+		{"application/json", "null", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "http://pingo.lan:7777/api/silences", nil)
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if status, _ := checkWriteRequest(r); status != tt.status {
+			t.Errorf("Content-Type %q, Origin %q: expected %d, got %d", tt.contentType, tt.origin, tt.status, status)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Silence suppresses alert notifications for a target and/or rule until it
+// expires. Rounds are still recorded and alerts still evaluated.
+type Silence struct {
+	ID      int64     `json:"id"`
+	Target  string    `json:"target"` // Empty for every target
+	Rule    string    `json:"rule"`   // Empty for every rule
+	Starts  time.Time `json:"starts"`
+	Expires time.Time `json:"expires"`
+	Comment string    `json:"comment"`
+}
+
+var errSilenceNotFound = errors.New("silence not found")
+
+func (s *Silence) validate() error {
+	if s.Starts.IsZero() || s.Expires.IsZero() {
+		return fmt.Errorf("starts and expires are required")
+	}
+	if !s.Expires.After(s.Starts) {
+		return fmt.Errorf("expires must be after starts")
+	}
+	s.Comment = strings.TrimSpace(s.Comment)
+	return nil
+}
+
+func createSilence(db *DB, s *Silence) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	result, err := db.Exec(`INSERT INTO silences (target, rule, starts, expires, comment) VALUES (?, ?, ?, ?, ?)`,
+		s.Target, s.Rule, toEpochMillis(s.Starts), toEpochMillis(s.Expires), s.Comment)
+	if err != nil {
+		return err
+	}
+	s.ID, err = result.LastInsertId()
+	return err
+}
+
+func deleteSilence(db *DB, id int64) error {
+	result, err := db.Exec(`DELETE FROM silences WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errSilenceNotFound
+	}
+	return nil
+}
+
+// listSilences returns silences overlapping start to end, earliest first.
+func listSilences(db *DB, start, end time.Time) ([]Silence, error) {
+	rows, err := db.Query(`SELECT id, target, rule, starts, expires, comment FROM silences
+		WHERE starts <= ? AND expires > ? ORDER BY starts ASC`,
+		toEpochMillis(end), toEpochMillis(start))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	silences := []Silence{}
+	for rows.Next() {
+		var s Silence
+		var starts, expires int64
+		if err := rows.Scan(&s.ID, &s.Target, &s.Rule, &starts, &expires, &s.Comment); err != nil {
+			return nil, err
+		}
+		s.Starts, s.Expires = fromEpochMillis(starts), fromEpochMillis(expires)
+		silences = append(silences, s)
+	}
+	return silences, rows.Err()
+}
+
+// MaintenanceWindow is a recurring period, such as an ISP's announced
+// maintenance, during which alert notifications are suppressed.
+type MaintenanceWindow struct {
+	Name     string        `toml:"name"`
+	Schedule string        `toml:"schedule"` // Cron expression for when the window starts, in the server's timezone
+	Duration time.Duration `toml:"duration"`
+	Targets  []string      `toml:"targets"` // Empty for every target
+	Rules    []string      `toml:"rules"`   // Empty for every rule
+}
+
+func (w MaintenanceWindow) validate(targets map[string]bool, rules map[string]bool) error {
+	if _, err := parseCron(w.Schedule); err != nil {
+		return err
+	}
+	if w.Duration <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	for _, t := range w.Targets {
+		if !targets[t] {
+			return fmt.Errorf("unknown target %q", t)
+		}
+	}
+	for _, r := range w.Rules {
+		if !rules[r] {
+			return fmt.Errorf("unknown alert %q", r)
+		}
+	}
+	return nil
+}
+
+// maintenanceWindow is a MaintenanceWindow with its schedule parsed.
+type maintenanceWindow struct {
+	MaintenanceWindow
+	schedule cronSchedule
+}
+
+func (w maintenanceWindow) covers(target, rule string) bool {
+	return (len(w.Targets) == 0 || slices.Contains(w.Targets, target)) &&
+		(len(w.Rules) == 0 || slices.Contains(w.Rules, rule))
+}
+
+// active reports whether a window started within Duration before t. Windows
+// start on the minute, so only those minutes need checking.
+func (w maintenanceWindow) active(t time.Time) bool {
+	start := t.Truncate(time.Minute)
+	for ; t.Sub(start) < w.Duration; start = start.Add(-time.Minute) {
+		if w.schedule.matches(start.In(time.Local)) {
+			return true
+		}
+	}
+	return false
+}
+
+// windowRange is a period [start, end).
+type windowRange struct {
+	start, end time.Time
+}
+
+// ranges returns the periods the window is active that overlap start to
+// end, checking each minute of the span against the schedule once.
+func (w maintenanceWindow) ranges(start, end time.Time) []windowRange {
+	var ranges []windowRange
+	for t := start.Add(-w.Duration).Truncate(time.Minute); !t.After(end); t = t.Add(time.Minute) {
+		if w.schedule.matches(t.In(time.Local)) && t.Add(w.Duration).After(start) {
+			ranges = append(ranges, windowRange{t, t.Add(w.Duration)})
+		}
+	}
+	return ranges
+}
+
+// mergeRanges sorts ranges and joins those that overlap.
+func mergeRanges(ranges []windowRange) []windowRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start.Before(ranges[j].start) })
+	var merged []windowRange
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && !r.start.After(merged[n-1].end) {
+			if r.end.After(merged[n-1].end) {
+				merged[n-1].end = r.end
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// silencer decides whether alert notifications are suppressed by an ad hoc
+// silence or a maintenance window.
+type silencer struct {
+	db      *DB // nil for maintenance windows only
+	windows []maintenanceWindow
+}
+
+func newSilencer(db *DB, windows []MaintenanceWindow) *silencer {
+	s := &silencer{db: db}
+	for _, w := range windows {
+		// Schedules were checked when the config was loaded
+		schedule, _ := parseCron(w.Schedule)
+		s.windows = append(s.windows, maintenanceWindow{w, schedule})
+	}
+	return s
+}
+
+// silenced returns why notifications for a rule and target are suppressed
+// at t, or "" when they aren't.
+func (s *silencer) silenced(target, rule string, t time.Time) string {
+	if s == nil {
+		return ""
+	}
+	for _, w := range s.windows {
+		if w.covers(target, rule) && w.active(t) {
+			return fmt.Sprintf("maintenance window %s", w.Name)
+		}
+	}
+	if s.db == nil {
+		return ""
+	}
+
+	silences, err := listSilences(s.db, t, t)
+	if err != nil {
+		log.Printf("Failed to check silences: %v", err)
+		return ""
+	}
+	for _, silence := range silences {
+		if (silence.Target == "" || silence.Target == target) && (silence.Rule == "" || silence.Rule == rule) {
+			return fmt.Sprintf("silence %d", silence.ID)
+		}
+	}
+	return ""
+}
+
+// excluded returns a check for whether a round of target between start and
+// end falls in a silence or maintenance window covering every rule, for
+// leaving those periods out of availability figures. The periods are
+// worked out once, so checking each round is a binary search.
+func (s *silencer) excluded(target string, start, end time.Time) (func(time.Time) bool, error) {
+	var ranges []windowRange
+	for _, w := range s.windows {
+		if len(w.Rules) == 0 && w.covers(target, "") {
+			ranges = append(ranges, w.ranges(start, end)...)
+		}
+	}
+	if s.db != nil {
+		silences, err := listSilences(s.db, start, end)
+		if err != nil {
+			return nil, err
+		}
+		for _, silence := range silences {
+			if silence.Rule == "" && (silence.Target == "" || silence.Target == target) {
+				ranges = append(ranges, windowRange{silence.Starts, silence.Expires})
+			}
+		}
+	}
+	ranges = mergeRanges(ranges)
+
+	return func(t time.Time) bool {
+		i := sort.Search(len(ranges), func(i int) bool { return ranges[i].end.After(t) })
+		return i < len(ranges) && !t.Before(ranges[i].start)
+	}, nil
+}
+
+// withoutExcluded drops the rounds that fall in excluded periods.
+func withoutExcluded(rounds []PingStats, excluded func(time.Time) bool) []PingStats {
+	kept := rounds[:0:0]
+	for _, r := range rounds {
+		if !excluded(r.Timestamp) {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSilences(t *testing.T) {
+	db, err := initDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now().Truncate(time.Millisecond)
+	silence := Silence{Target: "isp", Starts: now, Expires: now.Add(2 * time.Hour), Comment: " ISP maintenance "}
+	if err := createSilence(db, &silence); err != nil {
+		t.Fatalf("Failed to create silence: %v", err)
+	}
+	if silence.ID == 0 || silence.Comment != "ISP maintenance" {
+		t.Errorf("Unexpected silence %+v", silence)
+	}
+	if err := createSilence(db, &Silence{Starts: now, Expires: now}); err == nil {
+		t.Error("Expected an error for a silence that expires when it starts")
+	}
+
+	s := newSilencer(db, nil)
+	if reason := s.silenced("isp", "isp-down", now.Add(time.Hour)); reason == "" {
+		t.Error("Expected the silence to cover isp")
+	}
+	if reason := s.silenced("google", "isp-down", now.Add(time.Hour)); reason != "" {
+		t.Errorf("Expected google not to be silenced, got %q", reason)
+	}
+	if reason := s.silenced("isp", "isp-down", now.Add(3*time.Hour)); reason != "" {
+		t.Errorf("Expected the silence to have expired, got %q", reason)
+	}
+
+	silences, err := listSilences(db, now, now.AddDate(1, 0, 0))
+	if err != nil || len(silences) != 1 || !silences[0].Expires.Equal(silence.Expires) {
+		t.Fatalf("Expected the silence to be listed, got %+v (%v)", silences, err)
+	}
+
+	if err := deleteSilence(db, silence.ID); err != nil {
+		t.Fatalf("Failed to delete silence: %v", err)
+	}
+	if err := deleteSilence(db, silence.ID); err != errSilenceNotFound {
+		t.Errorf("Expected errSilenceNotFound, got %v", err)
+	}
+	if reason := s.silenced("isp", "isp-down", now.Add(time.Hour)); reason != "" {
+		t.Errorf("Expected no silence after deleting it, got %q", reason)
+	}
+}
+
+func TestMaintenanceWindows(t *testing.T) {
+	s := newSilencer(nil, []MaintenanceWindow{
+		{Name: "isp-nightly", Schedule: "0 2 * * *", Duration: 2 * time.Hour, Targets: []string{"isp"}},
+		{Name: "loss-only", Schedule: "30 12 * * *", Duration: 30 * time.Minute, Rules: []string{"isp-down"}},
+	})
+	day := time.Date(2025, 10, 19, 0, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		target, rule string
+		at           time.Duration
+		want         bool
+	}{
+		{"isp", "slow", 2 * time.Hour, true},
+		{"isp", "slow", 3*time.Hour + 59*time.Minute, true},
+		{"isp", "slow", 4 * time.Hour, false},
+		{"isp", "slow", time.Hour + 59*time.Minute, false},
+		{"google", "slow", 3 * time.Hour, false},
+		{"google", "isp-down", 12*time.Hour + 45*time.Minute, true},
+		{"google", "slow", 12*time.Hour + 45*time.Minute, false},
+	}
+	for _, tt := range tests {
+		got := s.silenced(tt.target, tt.rule, day.Add(tt.at)) != ""
+		if got != tt.want {
+			t.Errorf("%s/%s at %s: expected silenced=%v", tt.target, tt.rule, tt.at, tt.want)
+		}
+	}
+
+	// Only windows covering every rule are left out of availability
+	excluded, err := s.excluded("isp", day, day.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rounds := []PingStats{
+		{Timestamp: day.Add(time.Hour)},
+		{Timestamp: day.Add(3 * time.Hour), PacketLoss: 100},
+		{Timestamp: day.Add(12*time.Hour + 45*time.Minute)},
+	}
+	if kept := withoutExcluded(rounds, excluded); len(kept) != 2 || kept[1].Timestamp != rounds[2].Timestamp {
+		t.Errorf("Expected only the round in the nightly window to be dropped, got %+v", kept)
+	}
+}
+
+func TestMaintenanceWindowRanges(t *testing.T) {
+	windows := []MaintenanceWindow{
+		{Name: "nightly", Schedule: "0 2 * * *", Duration: 2 * time.Hour},
+		// Overlapping occurrences get merged
+		{Name: "frequent", Schedule: "*/30 10-11 * * 1-5", Duration: 45 * time.Minute},
+	}
+	s := newSilencer(nil, windows)
+	start := time.Date(2025, 10, 17, 0, 30, 0, 0, time.Local)
+	end := start.Add(72 * time.Hour)
+
+	excluded, err := s.excluded("isp", start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for at := start; at.Before(end); at = at.Add(7 * time.Minute) {
+		want := false
+		for _, w := range s.windows {
+			want = want || w.active(at)
+		}
+		if got := excluded(at); got != want {
+			t.Errorf("%s: expected excluded=%v", at.Format(time.DateTime), want)
+		}
+	}
+}
+
+func TestAlertsSuppressedBySilence(t *testing.T) {
+	base := time.Date(2025, 10, 19, 1, 55, 0, 0, time.Local)
+	rule := AlertRule{Name: "isp-down", Metric: MetricLoss, Threshold: 50, Notify: []string{"oncall"}}
+	m := newAlertManager([]AlertRule{rule}, nil)
+	m.silencer = newSilencer(nil, []MaintenanceWindow{{Name: "nightly", Schedule: "0 2 * * *", Duration: time.Hour}})
+
+	round := func(offset time.Duration, loss float64) []Alert {
+		return m.evaluate(&PingStats{Timestamp: base.Add(offset), Target: "isp", PacketLoss: loss})
+	}
+
+	// Fires before the window: sent, and resolved even inside the window
+	if alerts := round(0, 100); len(alerts) != 1 {
+		t.Fatalf("Expected the alert to fire, got %+v", alerts)
+	}
+	if alerts := round(10*time.Minute, 0); len(alerts) != 1 || alerts[0].Status != AlertResolved {
+		t.Fatalf("Expected the alert to resolve, got %+v", alerts)
+	}
+
+	// Fires inside the window: held back, and its resolution too
+	if alerts := round(15*time.Minute, 100); len(alerts) != 0 {
+		t.Fatalf("Expected the alert to be suppressed, got %+v", alerts)
+	}
+	if alerts := round(20*time.Minute, 0); len(alerts) != 0 {
+		t.Fatalf("Expected no resolution for a suppressed alert, got %+v", alerts)
+	}
+
+	// Still firing when the window ends: sent then, covering the whole incident
+	round(50*time.Minute, 100)
+	if alerts := round(65*time.Minute, 100); len(alerts) != 1 || alerts[0].Status != AlertFiring || alerts[0].Summary.Rounds != 2 {
+		t.Fatalf("Expected the alert to fire once the window ended, got %+v", alerts)
+	}
+}
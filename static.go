@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+//go:embed static
+var staticFS embed.FS
+
+// dashboardAssets are the third-party files the dashboard needs. Pinned
+// copies are committed to static/ by scripts/fetch-static.sh.
+var dashboardAssets = []string{
+	"pico.min.css",
+	"lightweight-charts.standalone.production.js",
+}
+
+// staticAssets serves embedded files under /static/ at URLs that include a
+// hash of their content, so browsers can cache them forever.
+type staticAssets struct {
+	urls  map[string]string // File name to hashed URL
+	files map[string][]byte // Hashed name to content
+}
+
+func loadStaticAssets(fsys fs.FS) (*staticAssets, error) {
+	assets := &staticAssets{urls: make(map[string]string), files: make(map[string][]byte)}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || (path.Ext(name) != ".js" && path.Ext(name) != ".css") {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+
+		// e.g. pico.min.css becomes pico.min.1a2b3c4d.css
+		sum := sha256.Sum256(data)
+		ext := path.Ext(name)
+		hashed := strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:4]) + ext
+
+		assets.urls[name] = "/static/" + hashed
+		assets.files[hashed] = data
+	}
+
+	for _, name := range dashboardAssets {
+		if _, ok := assets.urls[name]; !ok {
+			return nil, fmt.Errorf("%s is missing from static/, run scripts/fetch-static.sh", name)
+		}
+	}
+	return assets, nil
+}
+
+// url returns the hashed URL the page loads an asset from.
+func (a *staticAssets) url(name string) (string, error) {
+	url, ok := a.urls[name]
+	if !ok {
+		return "", fmt.Errorf("unknown asset %q", name)
+	}
+	return url, nil
+}
+
+func (a *staticAssets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/static/")
+	data, ok := a.files[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	// The URL changes whenever the content does
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+}
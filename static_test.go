@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestStaticAssets(t *testing.T) {
+	files := fstest.MapFS{
+		"pico.min.css": {Data: []byte("body{margin:0}")},
+		"lightweight-charts.standalone.production.js": {Data: []byte("var LightweightCharts={}")},
+		"README.md":  {Data: []byte("not served")},
+		"SHA256SUMS": {Data: []byte("not served either")},
+	}
+	assets, err := loadStaticAssets(files)
+	if err != nil {
+		t.Fatalf("Failed to load assets: %v", err)
+	}
+
+	url, err := assets.url("pico.min.css")
+	if err != nil || !strings.HasPrefix(url, "/static/pico.min.") || !strings.HasSuffix(url, ".css") || url == "/static/pico.min.css" {
+		t.Fatalf("Expected a content-hashed URL, got %q", url)
+	}
+
+	rec := httptest.NewRecorder()
+	assets.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "body{margin:0}" {
+		t.Fatalf("Expected the asset, got %d: %q", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/css") {
+		t.Errorf("Expected text/css, got %q", ct)
+	}
+	if cc := rec.Header().Get("Cache-Control"); !strings.Contains(cc, "immutable") {
+		t.Errorf("Expected an immutable cache header, got %q", cc)
+	}
+
+	// Only hashed names are served
+	for _, path := range []string{"/static/pico.min.css", "/static/README.md", "/static/SHA256SUMS"} {
+		rec := httptest.NewRecorder()
+		assets.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s: expected 404, got %d", path, rec.Code)
+		}
+	}
+
+	if _, err := assets.url("chart.js"); err == nil {
+		t.Error("Expected an error for an unknown asset")
+	}
+
+	// Nothing is loaded from a CDN, so a missing asset is an error
+	delete(files, "lightweight-charts.standalone.production.js")
+	if _, err := loadStaticAssets(files); err == nil {
+		t.Error("Expected an error for a missing dashboard asset")
+	}
+}
+
+// TestEmbeddedStaticAssets checks the files actually built into the binary,
+// which pingo refuses to start without.
+func TestEmbeddedStaticAssets(t *testing.T) {
+	files, err := fs.Sub(staticFS, "static")
+	if err != nil {
+		t.Fatalf("Failed to open embedded assets: %v", err)
+	}
+	if _, err := loadStaticAssets(files); err != nil {
+		t.Fatalf("Failed to load embedded assets: %v", err)
+	}
+
+	sums, err := fs.ReadFile(files, "SHA256SUMS")
+	if err != nil {
+		t.Fatalf("Failed to read checksums: %v", err)
+	}
+	checked := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(string(sums)), "\n") {
+		sum, name, ok := strings.Cut(line, "  ")
+		if !ok {
+			t.Fatalf("Malformed checksum line %q", line)
+		}
+		data, err := fs.ReadFile(files, name)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if got := sha256.Sum256(data); hex.EncodeToString(got[:]) != sum {
+			t.Errorf("%s doesn't match its checksum in SHA256SUMS", name)
+		}
+		checked[name] = true
+	}
+	for _, name := range dashboardAssets {
+		if !checked[name] {
+			t.Errorf("%s has no checksum in SHA256SUMS", name)
+		}
+	}
+}
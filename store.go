@@ -0,0 +1,149 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store holds ping rounds. *DB keeps them in SQLite; memStore keeps the most
+// recent rounds in memory for --ephemeral runs and tests.
+type Store interface {
+	// SaveStats stores rounds, all or none of them
+	SaveStats(stats ...*PingStats) error
+	// RecentStats returns the last limit rounds in chronological order
+	RecentStats(target, family string, limit int) ([]PingStats, error)
+	// StatsBetween returns rounds from start to end inclusive
+	StatsBetween(target, family string, start, end time.Time) ([]PingStats, error)
+	// StatsSince returns rounds after since
+	StatsSince(target, family string, since time.Time) ([]PingStats, error)
+	// PruneStats deletes rounds older than cutoff and returns how many
+	PruneStats(cutoff time.Time) (int64, error)
+	// DailySummaries returns per-day summaries from startDay to endDay
+	// (YYYY-MM-DD) inclusive
+	DailySummaries(target, family, startDay, endDay string) ([]DailySummary, error)
+}
+
+// ephemeralCapacity is how many rounds --ephemeral keeps, about 11 days of
+// one series at the default ping count.
+const ephemeralCapacity = 100000
+
+// memStore is a bounded ring buffer of rounds. Once full, each new round
+// overwrites the oldest one.
+type memStore struct {
+	mu     sync.RWMutex
+	rounds []PingStats
+	next   int // Index the next round is written to
+	full   bool
+}
+
+// storedTime truncates t to the millisecond UTC precision the database
+// stores, so both stores compare timestamps the same way.
+func storedTime(t time.Time) time.Time {
+	return fromEpochMillis(toEpochMillis(t))
+}
+
+func newMemStore(capacity int) *memStore {
+	return &memStore{rounds: make([]PingStats, capacity)}
+}
+
+func (m *memStore) SaveStats(stats ...*PingStats) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range stats {
+		round := *s
+		round.Timestamp = storedTime(s.Timestamp)
+
+		m.rounds[m.next] = round
+		m.next = (m.next + 1) % len(m.rounds)
+		if m.next == 0 {
+			m.full = true
+		}
+	}
+	return nil
+}
+
+// matching returns the stored rounds for a series that pass keep, sorted by
+// timestamp. Rounds are saved roughly in order, but targets finish their
+// rounds independently.
+func (m *memStore) matching(target, family string, keep func(s *PingStats) bool) []PingStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var stats []PingStats
+	m.each(func(s *PingStats) {
+		if (target != "" && s.Target != target) || (family != "" && s.Family != family) {
+			return
+		}
+		if keep(s) {
+			stats = append(stats, *s)
+		}
+	})
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].Timestamp.Before(stats[j].Timestamp)
+	})
+	return stats
+}
+
+// each calls fn with the stored rounds oldest first, walking both halves of
+// the ring in place. The caller must hold mu.
+func (m *memStore) each(fn func(s *PingStats)) {
+	if m.full {
+		for i := m.next; i < len(m.rounds); i++ {
+			fn(&m.rounds[i])
+		}
+	}
+	for i := 0; i < m.next; i++ {
+		fn(&m.rounds[i])
+	}
+}
+
+func (m *memStore) RecentStats(target, family string, limit int) ([]PingStats, error) {
+	stats := m.matching(target, family, func(*PingStats) bool { return true })
+	if len(stats) > limit {
+		stats = stats[len(stats)-limit:]
+	}
+	return stats, nil
+}
+
+func (m *memStore) StatsBetween(target, family string, start, end time.Time) ([]PingStats, error) {
+	start, end = storedTime(start), storedTime(end)
+	return m.matching(target, family, func(s *PingStats) bool {
+		return !s.Timestamp.Before(start) && !s.Timestamp.After(end)
+	}), nil
+}
+
+func (m *memStore) StatsSince(target, family string, since time.Time) ([]PingStats, error) {
+	since = storedTime(since)
+	return m.matching(target, family, func(s *PingStats) bool {
+		return s.Timestamp.After(since)
+	}), nil
+}
+
+func (m *memStore) PruneStats(cutoff time.Time) (int64, error) {
+	cutoff = storedTime(cutoff)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var kept []PingStats
+	m.each(func(s *PingStats) {
+		if !s.Timestamp.Before(cutoff) {
+			kept = append(kept, *s)
+		}
+	})
+	removed := m.size() - len(kept)
+
+	rounds := make([]PingStats, len(m.rounds))
+	copy(rounds, kept)
+	m.rounds, m.next, m.full = rounds, len(kept)%len(rounds), len(kept) == len(rounds)
+	return int64(removed), nil
+}
+
+// size returns the number of stored rounds. The caller must hold mu.
+func (m *memStore) size() int {
+	if m.full {
+		return len(m.rounds)
+	}
+	return m.next
+}
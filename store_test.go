@@ -0,0 +1,118 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testStores returns one of each Store implementation.
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+
+	db, err := initDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return map[string]Store{
+		"sqlite": db,
+		"memory": newMemStore(100),
+	}
+}
+
+func TestStoreQueries(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			baseTime := time.Date(2025, 10, 19, 12, 0, 0, 0, time.UTC)
+			var rounds []*PingStats
+			for i := 0; i < 5; i++ {
+				for _, target := range []string{"google", "isp"} {
+					rounds = append(rounds, &PingStats{
+						Timestamp: baseTime.Add(time.Duration(i) * time.Minute),
+						Target:    target,
+						Avg:       float64Ptr(float64(i)),
+					})
+				}
+			}
+			if err := store.SaveStats(rounds...); err != nil {
+				t.Fatalf("Failed to save stats: %v", err)
+			}
+
+			recent, err := store.RecentStats("isp", "", 3)
+			if err != nil {
+				t.Fatalf("Failed to get recent stats: %v", err)
+			}
+			if len(recent) != 3 || *recent[0].Avg != 2 || *recent[2].Avg != 4 {
+				t.Errorf("Expected the last 3 isp rounds in order, got %d rounds", len(recent))
+			}
+
+			between, err := store.StatsBetween("google", "", baseTime.Add(time.Minute), baseTime.Add(3*time.Minute))
+			if err != nil {
+				t.Fatalf("Failed to get stats between: %v", err)
+			}
+			if len(between) != 3 || *between[0].Avg != 1 {
+				t.Errorf("Expected 3 google rounds in range, got %d", len(between))
+			}
+
+			since, err := store.StatsSince("", "", baseTime.Add(3*time.Minute))
+			if err != nil {
+				t.Fatalf("Failed to get stats since: %v", err)
+			}
+			if len(since) != 2 {
+				t.Errorf("Expected 2 rounds after the cutoff across targets, got %d", len(since))
+			}
+
+			removed, err := store.PruneStats(baseTime.Add(2 * time.Minute))
+			if err != nil {
+				t.Fatalf("Failed to prune: %v", err)
+			}
+			if removed != 4 {
+				t.Errorf("Expected 4 rounds pruned, got %d", removed)
+			}
+			all, err := store.RecentStats("", "", 100)
+			if err != nil {
+				t.Fatalf("Failed to get recent stats: %v", err)
+			}
+			if len(all) != 6 {
+				t.Errorf("Expected 6 rounds left after pruning, got %d", len(all))
+			}
+		})
+	}
+}
+
+func TestMemStoreOverwritesOldest(t *testing.T) {
+	store := newMemStore(3)
+	baseTime := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := store.SaveStats(&PingStats{Timestamp: baseTime.Add(time.Duration(i) * time.Second), Avg: float64Ptr(float64(i))}); err != nil {
+			t.Fatalf("Failed to save stats: %v", err)
+		}
+	}
+
+	stats, err := store.RecentStats("", "", 10)
+	if err != nil {
+		t.Fatalf("Failed to get recent stats: %v", err)
+	}
+	if len(stats) != 3 {
+		t.Fatalf("Expected the buffer to hold 3 rounds, got %d", len(stats))
+	}
+	for i, s := range stats {
+		if *s.Avg != float64(i+2) {
+			t.Errorf("Expected round %d at position %d, got %v", i+2, i, *s.Avg)
+		}
+	}
+
+	// Space freed by pruning is reused
+	if _, err := store.PruneStats(baseTime.Add(4 * time.Second)); err != nil {
+		t.Fatalf("Failed to prune: %v", err)
+	}
+	if err := store.SaveStats(&PingStats{Timestamp: baseTime.Add(5 * time.Second), Avg: float64Ptr(5)}); err != nil {
+		t.Fatalf("Failed to save stats: %v", err)
+	}
+	stats, _ = store.RecentStats("", "", 10)
+	if len(stats) != 2 || *stats[0].Avg != 4 || *stats[1].Avg != 5 {
+		t.Errorf("Expected rounds 4 and 5 after pruning, got %d rounds", len(stats))
+	}
+}
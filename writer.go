@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// maxPendingRounds bounds the rounds buffered while commits keep failing;
+// beyond it the oldest are dropped.
+const maxPendingRounds = 10000
+
+// statsWriter saves ping rounds for the monitor. With a commit interval
+// set, rounds are buffered in memory and committed together in a single
+// transaction, trading the last interval's rounds on a crash or power loss
+// for far fewer writes to the SD card.
+type statsWriter struct {
+	store    Store
+	interval time.Duration
+	health   *monitorHealth // Told whether commits succeed, optional
+
+	mu      sync.Mutex
+	pending []*PingStats
+	dropped int // Rounds dropped since the last successful commit
+}
+
+func newStatsWriter(store Store, interval time.Duration) *statsWriter {
+	return &statsWriter{store: store, interval: interval}
+}
+
+// Save stores a round, or queues it for the next commit when batching.
+func (w *statsWriter) Save(stats *PingStats) error {
+	if w.interval <= 0 {
+		return savePingStats(w.store, stats)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.pending) >= maxPendingRounds {
+		if w.dropped == 0 {
+			log.Printf("Commit queue is full (%d rounds), dropping the oldest rounds until a commit succeeds", maxPendingRounds)
+		}
+		w.pending = w.pending[1:]
+		w.dropped++
+	}
+	w.pending = append(w.pending, stats)
+	return nil
+}
+
+// Flush commits all queued rounds in one transaction. On failure the
+// rounds stay queued and are retried on the next flush.
+func (w *statsWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.pending) == 0 {
+		return nil
+	}
+
+	err := w.store.SaveStats(w.pending...)
+	w.health.commitFinished(err, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if w.dropped > 0 {
+		log.Printf("Committed buffered rounds again; %d rounds were dropped while commits failed", w.dropped)
+		w.dropped = 0
+	}
+	w.pending = nil
+	return nil
+}
+
+// Run flushes queued rounds every commit interval.
+func (w *statsWriter) Run() {
+	log.Printf("Committing ping rounds every %s", w.interval)
+	for {
+		time.Sleep(w.interval)
+		if err := w.Flush(); err != nil {
+			log.Printf("Failed to commit buffered stats: %v", err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func countPingStats(t *testing.T, db *DB) int {
+	t.Helper()
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM ping_stats`).Scan(&count); err != nil {
+		t.Fatalf("Failed to count rows: %v", err)
+	}
+	return count
+}
+
+func TestStatsWriterUnbuffered(t *testing.T) {
+	db, err := initDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	writer := newStatsWriter(db, 0)
+	if err := writer.Save(&PingStats{Timestamp: time.Now()}); err != nil {
+		t.Fatalf("Failed to save stats: %v", err)
+	}
+
+	if count := countPingStats(t, db); count != 1 {
+		t.Errorf("Expected the round to be written immediately, got %d rows", count)
+	}
+}
+
+func TestStatsWriterBatched(t *testing.T) {
+	db, err := initDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	writer := newStatsWriter(db, time.Minute)
+	baseTime := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := writer.Save(&PingStats{Timestamp: baseTime.Add(time.Duration(i) * time.Second), Target: "isp"}); err != nil {
+			t.Fatalf("Failed to save stats: %v", err)
+		}
+	}
+
+	if count := countPingStats(t, db); count != 0 {
+		t.Errorf("Expected rounds to stay buffered until flushed, got %d rows", count)
+	}
+
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if count := countPingStats(t, db); count != 3 {
+		t.Errorf("Expected 3 rows after flush, got %d", count)
+	}
+
+	// Nothing left to commit
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("Failed to flush empty buffer: %v", err)
+	}
+	if count := countPingStats(t, db); count != 3 {
+		t.Errorf("Expected rounds to be committed once, got %d rows", count)
+	}
+}
+
+// failingStore is a memStore whose saves fail while err is set.
+type failingStore struct {
+	*memStore
+	err error
+}
+
+func (s *failingStore) SaveStats(stats ...*PingStats) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.memStore.SaveStats(stats...)
+}
+
+func TestStatsWriterCommitFailure(t *testing.T) {
+	store := &failingStore{memStore: newMemStore(maxPendingRounds + 10), err: errors.New("disk I/O error")}
+	now := time.Now()
+	health := newMonitorHealth(now)
+	writer := newStatsWriter(store, time.Minute)
+	writer.health = health
+	series := []SeriesInfo{{Target: "isp", Label: "isp"}}
+
+	base := now.Add(-time.Hour)
+	for i := 0; i < maxPendingRounds+5; i++ {
+		writer.Save(&PingStats{Timestamp: base.Add(time.Duration(i) * time.Millisecond), Target: "isp"})
+	}
+	health.roundCompleted("isp", now)
+
+	if err := writer.Flush(); err == nil {
+		t.Fatal("Expected the commit to fail")
+	}
+	report := health.report(series, time.Minute, nil, now)
+	if report.Ready || !strings.Contains(report.Database, "disk I/O error") {
+		t.Errorf("Expected the failed commit to make pingo unready, got %+v", report)
+	}
+	if len(writer.pending) != maxPendingRounds || !writer.pending[0].Timestamp.Equal(base.Add(5*time.Millisecond)) {
+		t.Fatalf("Expected the queue capped at %d with the oldest rounds dropped, got %d", maxPendingRounds, len(writer.pending))
+	}
+
+	store.err = nil
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if report := health.report(series, time.Minute, nil, now); !report.Ready {
+		t.Errorf("Expected pingo to be ready once commits succeed, got %+v", report)
+	}
+	if rounds, _ := store.RecentStats("isp", "", maxPendingRounds+10); len(rounds) != maxPendingRounds {
+		t.Errorf("Expected the queued rounds to be committed, got %d", len(rounds))
+	}
+}